@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+	"k8s.io/klog/v2"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/controller"
+)
+
+// The apiVersion and kind of the versioned config file.
+const (
+	configAPIVersion = "rulescontroller.k8s.healthjoy.com/v1alpha1"
+	configKind       = "ControllerConfig"
+)
+
+// configReloadDelay is how long the watcher waits for the changes of the
+// config file to settle before reloading it.
+const configReloadDelay = time.Second
+
+// reloadableFlags are the flags of the defaults section of the config file,
+// which are applied without a restart together with the policies.
+var reloadableFlags = map[string]bool{
+	"mimir-namespace-template":         true,
+	"detect-duplicate-alerts":          true,
+	"unselected-policy":                true,
+	"aggregate-namespaces":             true,
+	"ruler-max-rules-per-rule-group":   true,
+	"ruler-max-rule-groups-per-tenant": true,
+}
+
+// processFlags are the flags locating the config file and the Kubernetes API,
+// which only apply on the command line.
+var processFlags = map[string]bool{
+	"config":     true,
+	"kubeconfig": true,
+	"master":     true,
+}
+
+// configFileV1alpha1 is the versioned config file. The controller and
+// defaults sections set flags by name, the mimir section sets the mimir-*
+// flags without their prefix.
+type configFileV1alpha1 struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Controller map[string]interface{} `yaml:"controller"`
+	Mimir      map[string]interface{} `yaml:"mimir"`
+	Defaults   map[string]interface{} `yaml:"defaults"`
+	Policies   []controller.Policy    `yaml:"policies"`
+}
+
+var (
+	configReloadSuccessGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mimir_rules_controller_config_last_reload_successful",
+		Help: "Whether the last reload of the config file succeeded",
+	})
+	configReloadTimestampGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mimir_rules_controller_config_last_reload_success_timestamp_seconds",
+		Help: "Time of the last successful reload of the config file",
+	})
+)
+
+// configLoader sets the flags of a FlagSet from a YAML file. Flags given on the
+// command line take precedence over the file.
+type configLoader struct {
+	fs   *flag.FlagSet
+	path string
+	// explicit are the flags given on the command line.
+	explicit map[string]bool
+	// data is the content of the file last applied.
+	data []byte
+	// values are the flags set from the file.
+	values map[string]string
+	// policies are the policies of the file.
+	policies []controller.Policy
+}
+
+// loadConfigFile sets the flags of fs from the config file at path.
+func loadConfigFile(fs *flag.FlagSet, path string) (*configLoader, error) {
+	f := &configLoader{fs: fs, path: path, explicit: map[string]bool{}}
+	fs.Visit(func(fl *flag.Flag) {
+		f.explicit[fl.Name] = true
+	})
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	if _, err := f.apply(data); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// apply sets the flags from data in place of the flags set from the previous
+// content, and returns the flags whose value changed. On error the flags of
+// the previous content are restored.
+func (f *configLoader) apply(data []byte) ([]string, error) {
+	values, policies, err := parseConfigFile(f.fs, data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", f.path, err)
+	}
+
+	previous := f.values
+	if err := f.set(values); err != nil {
+		_ = f.set(previous)
+		return nil, fmt.Errorf("invalid config file %s: %w", f.path, err)
+	}
+
+	var changed []string
+	for name, value := range values {
+		if old, ok := previous[name]; !ok || old != value {
+			changed = append(changed, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := values[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	f.data = data
+	f.policies = policies
+	return changed, nil
+}
+
+// set resets the flags set from the file to their default and sets values,
+// skipping the flags given on the command line.
+func (f *configLoader) set(values map[string]string) error {
+	for name := range f.values {
+		if !f.explicit[name] {
+			_ = f.fs.Set(name, f.fs.Lookup(name).DefValue)
+		}
+	}
+	f.values = values
+	for name, value := range values {
+		if f.explicit[name] {
+			continue
+		}
+		if err := f.fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for setting %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// reload applies the config file again if its content changed, then calls
+// update with the new flags in place. When update fails the previous flags
+// are restored. It returns the changed flags that only apply after a
+// restart.
+func (f *configLoader) reload(update func() error) ([]string, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	if bytes.Equal(data, f.data) {
+		return nil, nil
+	}
+
+	previousData := f.data
+	changed, err := f.apply(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(); err != nil {
+		// The previous content was applied once, it is valid
+		_, _ = f.apply(previousData)
+		return nil, err
+	}
+
+	var restart []string
+	for _, name := range changed {
+		if !reloadableFlags[name] && !f.explicit[name] {
+			restart = append(restart, name)
+		}
+	}
+	return restart, nil
+}
+
+// watch reloads the config file with update whenever it changes, until ctx
+// is done. The directory of the file is watched rather than the file, so
+// that the atomic updates of a mounted ConfigMap, which swap a symlink, are
+// seen. An invalid file is reported and the running config is kept.
+func (f *configLoader) watch(ctx context.Context, update func() error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error watching config file: %w", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(filepath.Dir(f.path)); err != nil {
+		return fmt.Errorf("error watching config file: %w", err)
+	}
+	configReloadSuccessGauge.Set(1)
+	configReloadTimestampGauge.SetToCurrentTime()
+	klog.InfoS("Watching the config file for changes", "path", f.path)
+
+	base := filepath.Base(f.path)
+	timer := time.NewTimer(0)
+	<-timer.C
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if name := filepath.Base(event.Name); name == base || name == "..data" {
+				timer.Reset(configReloadDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			klog.ErrorS(err, "Error watching the config file", "path", f.path)
+		case <-timer.C:
+			restart, err := f.reload(update)
+			if err != nil {
+				klog.ErrorS(err, "Error reloading the config file, keeping the running configuration", "path", f.path)
+				configReloadSuccessGauge.Set(0)
+				continue
+			}
+			configReloadSuccessGauge.Set(1)
+			configReloadTimestampGauge.SetToCurrentTime()
+			if len(restart) > 0 {
+				klog.InfoS("Changed settings of the config file only apply after a restart", "path", f.path, "settings", restart)
+			}
+		}
+	}
+}
+
+// parseConfigFile returns the flag values and policies of a config file.
+// A file without apiVersion is a flat mapping of flag names to values, e.g.
+// "workers: 4", and has no policies; that format is deprecated.
+func parseConfigFile(fs *flag.FlagSet, data []byte) (map[string]string, []controller.Policy, error) {
+	var header struct {
+		APIVersion string `yaml:"apiVersion"`
+	}
+	if err := yaml.Unmarshal(data, &header); err != nil {
+		return nil, nil, err
+	}
+
+	values := map[string]string{}
+	if header.APIVersion == "" {
+		klog.InfoS("Config files without apiVersion are deprecated, use the versioned format", "apiVersion", configAPIVersion, "kind", configKind)
+		settings := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &settings); err != nil {
+			return nil, nil, err
+		}
+		for name, value := range settings {
+			if processFlags[name] {
+				return nil, nil, fmt.Errorf("setting %q can only be given on the command line", name)
+			}
+			if fs.Lookup(name) == nil {
+				return nil, nil, fmt.Errorf("unknown setting %q", name)
+			}
+			values[name] = flagValue(value)
+		}
+		return values, nil, nil
+	}
+
+	var file configFileV1alpha1
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, nil, err
+	}
+	if file.APIVersion != configAPIVersion || file.Kind != configKind {
+		return nil, nil, fmt.Errorf("unsupported config file %s %s, expected %s %s", file.APIVersion, file.Kind, configAPIVersion, configKind)
+	}
+
+	var errs []error
+	for name, value := range file.Controller {
+		if processFlags[name] {
+			errs = append(errs, fmt.Errorf("setting %q can only be given on the command line", name))
+			continue
+		}
+		if fs.Lookup(name) == nil || strings.HasPrefix(name, "mimir-") || reloadableFlags[name] {
+			errs = append(errs, fmt.Errorf("unknown setting %q in the controller section", name))
+			continue
+		}
+		values[name] = flagValue(value)
+	}
+	for name, value := range file.Mimir {
+		flagName := "mimir-" + name
+		if fs.Lookup(flagName) == nil || reloadableFlags[flagName] {
+			errs = append(errs, fmt.Errorf("unknown setting %q in the mimir section", name))
+			continue
+		}
+		values[flagName] = flagValue(value)
+	}
+	for name, value := range file.Defaults {
+		if !reloadableFlags[name] {
+			errs = append(errs, fmt.Errorf("unknown setting %q in the defaults section", name))
+			continue
+		}
+		values[name] = flagValue(value)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, nil, err
+	}
+	return values, file.Policies, nil
+}
+
+// flagValue formats a YAML value as a flag value. Lists, such as the watched
+// namespaces, become comma separated.
+func flagValue(value interface{}) string {
+	list, ok := value.([]interface{})
+	if !ok {
+		return fmt.Sprint(value)
+	}
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		items = append(items, fmt.Sprint(item))
+	}
+	return strings.Join(items, ",")
+}
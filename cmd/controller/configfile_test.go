@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testFlagSet returns a FlagSet with a few flags of each config file section.
+func testFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("workers", 2, "")
+	fs.Int("shards", 1, "")
+	fs.String("watch-namespaces", "", "")
+	fs.String("mimir-address", "", "")
+	fs.Bool("detect-duplicate-alerts", false, "")
+	fs.String("unselected-policy", "retain", "")
+	return fs
+}
+
+const configHeader = "apiVersion: rulescontroller.k8s.healthjoy.com/v1alpha1\nkind: ControllerConfig\n"
+
+func TestParseConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "versioned",
+			data: configHeader + `
+controller:
+  workers: 4
+  watch-namespaces: [a, b]
+mimir:
+  address: http://mimir
+defaults:
+  detect-duplicate-alerts: true
+policies:
+- name: team
+  namespaces: [team-*]
+`,
+			want: map[string]string{
+				"workers":                 "4",
+				"watch-namespaces":        "a,b",
+				"mimir-address":           "http://mimir",
+				"detect-duplicate-alerts": "true",
+			},
+		},
+		{name: "unknown section", data: configHeader + "controler:\n  workers: 4\n", wantErr: "field controler not found"},
+		{name: "unknown policy field", data: configHeader + "policies:\n- name: a\n  namespace: [a]\n", wantErr: "field namespace not found"},
+		{name: "wrong kind", data: "apiVersion: rulescontroller.k8s.healthjoy.com/v1alpha1\nkind: Config\n", wantErr: "unsupported config file"},
+		{name: "unknown controller setting", data: configHeader + "controller:\n  worker: 4\n", wantErr: `unknown setting "worker" in the controller section`},
+		{name: "mimir setting in controller", data: configHeader + "controller:\n  mimir-address: http://mimir\n", wantErr: `unknown setting "mimir-address" in the controller section`},
+		{name: "default in controller", data: configHeader + "controller:\n  unselected-policy: release\n", wantErr: `unknown setting "unselected-policy" in the controller section`},
+		{name: "prefixed mimir setting", data: configHeader + "mimir:\n  mimir-address: http://mimir\n", wantErr: `unknown setting "mimir-address" in the mimir section`},
+		{name: "restart setting in defaults", data: configHeader + "defaults:\n  workers: 4\n", wantErr: `unknown setting "workers" in the defaults section`},
+		{name: "config in controller", data: configHeader + "controller:\n  config: /etc/other.yaml\n", wantErr: `setting "config" can only be given on the command line`},
+		{name: "kubeconfig in controller", data: configHeader + "controller:\n  kubeconfig: /root/.kube/config\n", wantErr: `setting "kubeconfig" can only be given on the command line`},
+		{name: "master in controller", data: configHeader + "controller:\n  master: https://10.0.0.1\n", wantErr: `setting "master" can only be given on the command line`},
+		{name: "legacy", data: "workers: 4\nmimir-address: http://mimir\n", want: map[string]string{"workers": "4", "mimir-address": "http://mimir"}},
+		{name: "legacy unknown setting", data: "worker: 4\n", wantErr: `unknown setting "worker"`},
+		{name: "legacy kubeconfig", data: "kubeconfig: /root/.kube/config\n", wantErr: `setting "kubeconfig" can only be given on the command line`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := parseConfigFile(testFlagSet(), []byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseConfigFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfigFile() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseConfigFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeConfigFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, configHeader+"controller:\n  workers: 4\n  shards: 3\n")
+
+	tests := []struct {
+		name        string
+		args        []string
+		wantWorkers string
+		wantShards  string
+	}{
+		{name: "file", wantWorkers: "4", wantShards: "3"},
+		{name: "command line first", args: []string{"-workers=8"}, wantWorkers: "8", wantShards: "3"},
+		{name: "command line default value", args: []string{"-shards=1"}, wantWorkers: "4", wantShards: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := testFlagSet()
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadConfigFile(fs, path); err != nil {
+				t.Fatalf("loadConfigFile() error = %v", err)
+			}
+			if got := fs.Lookup("workers").Value.String(); got != tt.wantWorkers {
+				t.Errorf("workers = %s, want %s", got, tt.wantWorkers)
+			}
+			if got := fs.Lookup("shards").Value.String(); got != tt.wantShards {
+				t.Errorf("shards = %s, want %s", got, tt.wantShards)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileInvalidValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, configHeader+"controller:\n  workers: many\n")
+	if _, err := loadConfigFile(testFlagSet(), path); err == nil || !strings.Contains(err.Error(), `invalid value for setting "workers"`) {
+		t.Fatalf("loadConfigFile() error = %v, want an invalid value", err)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	initial := configHeader + "controller:\n  workers: 4\ndefaults:\n  unselected-policy: release\n"
+	writeConfigFile(t, path, initial)
+	fs := testFlagSet()
+	if err := fs.Parse([]string{"-shards=2"}); err != nil {
+		t.Fatal(err)
+	}
+	loader, err := loadConfigFile(fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := func() map[string]string {
+		got := map[string]string{}
+		fs.VisitAll(func(fl *flag.Flag) {
+			got[fl.Name] = fl.Value.String()
+		})
+		return got
+	}
+	loaded := values()
+	ok := func() error { return nil }
+
+	tests := []struct {
+		name        string
+		data        string
+		update      func() error
+		wantRestart []string
+		wantErr     bool
+		// want are the expected flags, the loaded flags if nil
+		want map[string]string
+	}{
+		{name: "unchanged", data: initial, update: ok},
+		{
+			name:   "rejected by update",
+			data:   configHeader + "controller:\n  workers: 6\ndefaults:\n  unselected-policy: delete\n",
+			update: func() error { return errors.New("rejected") }, wantErr: true,
+		},
+		{name: "invalid file", data: configHeader + "controller:\n  workers: [\n", update: ok, wantErr: true},
+		{name: "invalid value", data: configHeader + "controller:\n  workers: many\n", update: ok, wantErr: true},
+		{
+			name:        "reloadable and restart settings",
+			data:        configHeader + "controller:\n  workers: 6\n  shards: 5\ndefaults:\n  unselected-policy: delete\n",
+			update:      ok,
+			wantRestart: []string{"workers"},
+			want: map[string]string{
+				"workers": "6", "shards": "2", "watch-namespaces": "", "mimir-address": "",
+				"detect-duplicate-alerts": "false", "unselected-policy": "delete",
+			},
+		},
+		{
+			name:        "removed settings",
+			data:        configHeader,
+			update:      ok,
+			wantRestart: []string{"workers"},
+			want: map[string]string{
+				"workers": "2", "shards": "2", "watch-namespaces": "", "mimir-address": "",
+				"detect-duplicate-alerts": "false", "unselected-policy": "retain",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfigFile(t, path, tt.data)
+			restart, err := loader.reload(tt.update)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(restart, tt.wantRestart) {
+				t.Errorf("reload() = %v, want %v", restart, tt.wantRestart)
+			}
+			want := tt.want
+			if want == nil {
+				want = loaded
+			}
+			if got := values(); !reflect.DeepEqual(got, want) {
+				t.Errorf("flags = %v, want %v", got, want)
+			}
+			if tt.wantErr {
+				// The next reload starts from the loaded file
+				writeConfigFile(t, path, initial)
+			}
+		})
+	}
+}
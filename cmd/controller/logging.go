@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap/zapcore"
+	"k8s.io/klog/v2"
+	ctrllog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// Log formats.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// setupLogging writes the logs in the given format, including the logs of
+// controller-runtime. The JSON format also applies to the logs of the
+// mimirtool client and honors the klog verbosity.
+func setupLogging(format string) error {
+	switch format {
+	case logFormatText:
+		ctrllog.SetLogger(klog.NewKlogr())
+		return nil
+	case logFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, logFormatText, logFormatJSON)
+	}
+
+	verbosity := 0
+	if v := flag.Lookup("v"); v != nil {
+		verbosity, _ = strconv.Atoi(v.Value.String())
+	}
+	logger := zap.New(func(o *zap.Options) {
+		o.Development = false
+		o.Level = zapcore.Level(-verbosity)
+		o.StacktraceLevel = zapcore.PanicLevel
+		o.TimeEncoder = zapcore.ISO8601TimeEncoder
+	})
+	klog.SetLogger(logger)
+	ctrllog.SetLogger(logger)
+	logrus.SetFormatter(&logrus.JSONFormatter{
+		TimestampFormat: "2006-01-02T15:04:05.000Z0700",
+		FieldMap:        logrus.FieldMap{logrus.FieldKeyTime: "ts"},
+	})
+	return nil
+}
+
+// fatal logs err and exits.
+func fatal(err error, msg string, keysAndValues ...interface{}) {
+	klog.ErrorS(err, msg, keysAndValues...)
+	klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+}
@@ -3,32 +3,43 @@ package main
 import (
 	"context"
 	"flag"
-	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/grafana/mimir/pkg/mimirtool/client"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/tools/leaderelection"
-	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	"github.com/healthjoy/mimir-rules-controller/pkg/controller"
 	rulesclientset "github.com/healthjoy/mimir-rules-controller/pkg/generated/clientset/versioned"
 	rulesinformers "github.com/healthjoy/mimir-rules-controller/pkg/generated/informers/externalversions"
 	"github.com/healthjoy/mimir-rules-controller/pkg/metrics"
+	"github.com/healthjoy/mimir-rules-controller/pkg/mimir"
+	"github.com/healthjoy/mimir-rules-controller/pkg/tracing"
 )
 
 var (
-	masterURL  string
-	kubeconfig string
-	address    string
-	config     controller.Config
-	mmConf     client.Config
+	masterURL       string
+	kubeconfig      string
+	address         string
+	probeAddress    string
+	configFile      string
+	watchNamespaces string
+	logFormat       string
+	config          controller.Config
+	mmConf          client.Config
+	mmOptions       mimir.Options
+	tracingOptions  tracing.Options
 )
 
 func init() {
@@ -36,13 +47,45 @@ func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
 
+	flag.StringVar(&logFormat, "log-format", getEnv("LOG_FORMAT", logFormatText), "The format of the logs: text or json")
+	flag.StringVar(&configFile, "config", getEnv("CONFIG_FILE", ""), "Path to the YAML config file. Flags given on the command line take precedence, and changes of the file are applied live where possible.")
+
 	// Controller config
 	flag.StringVar(&address, "address", ":9000", "The address to expose prometheus metrics and service endpoints.")
+	flag.StringVar(&probeAddress, "health-probe-address", getEnv("HEALTH_PROBE_ADDRESS", ":8081"), "The address serving the /healthz and /readyz probes.")
 	flag.StringVar(&config.ClusterName, "cluster-name", getEnv("CLUSTER_NAME", "default"), "The name of the cluster. Used to identify the cluster in the Mimir API.")
+	flag.StringVar(&config.ClusterUID, "cluster-uid", getEnv("CLUSTER_UID", ""), "The unique ID of the cluster marking the Mimir namespaces it owns. Defaults to the UID of the kube-system namespace")
+	flag.BoolVar(&config.OwnershipMarkers, "ownership-markers", getEnv("OWNERSHIP_MARKERS", "true") == "true", "Whether to mark the Mimir namespaces with an owner rule group and leave alone the namespaces owned by another controller or cluster. Each marker counts as a rule group of the tenant")
 	flag.StringVar(&config.PodName, "pod-name", getEnv("POD_NAME", ""), "The name of the pod")
 	flag.StringVar(&config.PodNamespace, "pod-namespace", getEnv("POD_NAMESPACE", ""), "The namespace of the pod")
 	flag.StringVar(&config.LeaseLockName, "lease-lock-name", getEnv("LEASE_LOCK_NAME", "mimir-rules-controller"), "The name of the lease lock resource")
 	flag.StringVar(&config.LeaseLockNamespace, "lease-lock-namespace", getEnv("LEASE_LOCK_NAMESPACE", ""), "The namespace of the lease lock resource")
+	flag.StringVar(&config.ControllerName, "controller-name", getEnv("CONTROLLER_NAME", controller.DefaultControllerName), "The name of the controller. Only MimirRules with a matching spec.controllerName are managed")
+	flag.BoolVar(&config.DefaultController, "default-controller", getEnv("DEFAULT_CONTROLLER", "true") == "true", "Whether to also manage MimirRules without a spec.controllerName")
+	flag.DurationVar(&config.MimirCheckInterval, "mimir-check-interval", getEnvDuration("MIMIR_CHECK_INTERVAL", controller.DefaultMimirCheckInterval), "The interval at which the connectivity to the Mimir ruler API is checked. Rules are paused while Mimir is unreachable. 0 disables the check")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", getEnv("WATCH_NAMESPACES", ""), "Comma separated list of the namespaces of the MimirRules managed by the controller. Empty means all namespaces")
+	flag.StringVar(&config.NamespaceSelector, "namespace-selector", getEnv("NAMESPACE_SELECTOR", ""), "Label selector the namespace of a MimirRule must match for the rule to be managed by the controller")
+	flag.StringVar(&config.RuleSelector, "rule-selector", getEnv("RULE_SELECTOR", ""), "Label selector a MimirRule must match to be managed by the controller")
+	flag.StringVar(&config.UnselectedPolicy, "unselected-policy", getEnv("UNSELECTED_POLICY", controller.UnselectedPolicyDelete), "What happens to the rule groups of a MimirRule that stops matching the selectors: delete or retain")
+	flag.BoolVar(&config.AggregateNamespaces, "aggregate-namespaces", getEnv("AGGREGATE_NAMESPACES", "false") == "true", "Whether to merge the MimirRules of a Kubernetes namespace into one Mimir namespace named <cluster>:<namespace>, prefixing the rule groups with the name of their MimirRule")
+	flag.BoolVar(&config.DetectDuplicateAlerts, "detect-duplicate-alerts", getEnv("DETECT_DUPLICATE_ALERTS", "false") == "true", "Whether to report alert names used by more than one MimirRule in the same namespace")
+	flag.IntVar(&config.MaxRulesPerRuleGroup, "ruler-max-rules-per-rule-group", getEnvInt("RULER_MAX_RULES_PER_RULE_GROUP", 0), "The maximum number of rules per rule group accepted by Mimir. 0 disables the check")
+	flag.IntVar(&config.MaxRuleGroupsPerTenant, "ruler-max-rule-groups-per-tenant", getEnvInt("RULER_MAX_RULE_GROUPS_PER_TENANT", 0), "The maximum number of rule groups per tenant accepted by Mimir. 0 disables the check")
+	flag.DurationVar(&config.RulerLimitsRefreshInterval, "ruler-limits-refresh-interval", getEnvDuration("RULER_LIMITS_REFRESH_INTERVAL", 0), "The interval at which the ruler limits are fetched from the Mimir user limits API. 0 disables fetching")
+	flag.IntVar(&config.Workers, "workers", getEnvInt("WORKERS", controller.DefaultWorkers), "The number of rules processed concurrently")
+	flag.IntVar(&config.GroupConcurrency, "group-concurrency", getEnvInt("GROUP_CONCURRENCY", controller.DefaultGroupConcurrency), "The number of rule groups of a rule pushed to Mimir concurrently")
+	flag.DurationVar(&config.ResyncPeriod, "resync-period", getEnvDuration("RESYNC_PERIOD", controller.DefaultResyncPeriod), "The resync period of the informers")
+	flag.DurationVar(&config.LeaseDuration, "lease-duration", getEnvDuration("LEASE_DURATION", controller.DefaultLeaseDuration), "The duration non-leader candidates wait before trying to acquire the lease")
+	flag.DurationVar(&config.RenewDeadline, "lease-renew-deadline", getEnvDuration("LEASE_RENEW_DEADLINE", controller.DefaultRenewDeadline), "The duration the leader retries refreshing the lease before giving up")
+	flag.DurationVar(&config.RetryPeriod, "lease-retry-period", getEnvDuration("LEASE_RETRY_PERIOD", controller.DefaultRetryPeriod), "The duration candidates wait between tries of leader election actions")
+	flag.DurationVar(&config.WorkerStallTimeout, "worker-stall-timeout", getEnvDuration("WORKER_STALL_TIMEOUT", controller.DefaultWorkerStallTimeout), "How long rules may wait in the work queue without progress before the liveness check fails. 0 disables the check")
+	flag.IntVar(&config.Shards, "shards", getEnvInt("SHARDS", controller.DefaultShards), "The number of shards the MimirRules are split into, each reconciled by the replica holding its lease")
+	flag.DurationVar(&config.ShutdownTimeout, "shutdown-timeout", getEnvDuration("SHUTDOWN_TIMEOUT", controller.DefaultShutdownTimeout), "The duration in-flight rules are given to finish when the controller stops or loses the lease")
+	flag.DurationVar(&config.RateLimiterBaseDelay, "rate-limiter-base-delay", getEnvDuration("RATE_LIMITER_BASE_DELAY", controller.DefaultRateLimiterBaseDelay), "The first retry delay of a failed rule, doubled on each failure")
+	flag.DurationVar(&config.RateLimiterMaxDelay, "rate-limiter-max-delay", getEnvDuration("RATE_LIMITER_MAX_DELAY", controller.DefaultRateLimiterMaxDelay), "The maximum retry delay of a failed rule")
+	flag.Float64Var(&config.RateLimiterQPS, "rate-limiter-qps", getEnvFloat("RATE_LIMITER_QPS", controller.DefaultRateLimiterQPS), "The overall rate of rules added to the work queue")
+	flag.IntVar(&config.RateLimiterBurst, "rate-limiter-burst", getEnvInt("RATE_LIMITER_BURST", controller.DefaultRateLimiterBurst), "The overall burst of rules added to the work queue")
+	flag.StringVar(&config.NamespaceTemplate, "mimir-namespace-template", getEnv("MIMIR_NAMESPACE_TEMPLATE", controller.DefaultNamespaceTemplate), "The Go template used to name the Mimir namespace of a MimirRule. Available fields: .Cluster, .Namespace, .Name, .Labels, .Annotations")
 
 	// Mimic client config
 	flag.StringVar(&mmConf.User, "mimir-user", getEnv("MIMIR_USER", ""), "The username for the Mimir API")
@@ -57,103 +100,199 @@ func init() {
 	flag.StringVar(&mmConf.TLS.ServerName, "mimir-server-name", getEnv("MIMIR_SERVER_NAME", ""), "The server name for the Mimir API")
 	flag.StringVar(&mmConf.TLS.CipherSuites, "mimir-tls-cipher-suites", getEnv("MIMIR_TLS_CIPHER_SUITES", ""), "The cipher suites for the Mimir API")
 	flag.StringVar(&mmConf.TLS.MinVersion, "mimir-tls-min-version", getEnv("MIMIR_TLS_MIN_VERSION", ""), "The minimum TLS version for the Mimir API")
+	flag.Float64Var(&mmOptions.QPS, "mimir-qps", getEnvFloat("MIMIR_QPS", mimir.DefaultQPS), "The rate of requests sent to the Mimir API. 0 disables the limit")
+	flag.IntVar(&mmOptions.Burst, "mimir-burst", getEnvInt("MIMIR_BURST", mimir.DefaultBurst), "The burst of requests sent to the Mimir API")
+	flag.IntVar(&mmOptions.BreakerFailures, "mimir-breaker-failures", getEnvInt("MIMIR_BREAKER_FAILURES", mimir.DefaultBreakerFailures), "The number of consecutive failed Mimir requests that opens the circuit breaker. 0 disables the breaker")
+	flag.DurationVar(&mmOptions.BreakerOpenDuration, "mimir-breaker-open-duration", getEnvDuration("MIMIR_BREAKER_OPEN_DURATION", mimir.DefaultBreakerOpenDuration), "How long the circuit breaker stays open before probing Mimir again")
+	flag.IntVar(&mmOptions.MaxConcurrentRequests, "mimir-max-concurrent-requests", getEnvInt("MIMIR_MAX_CONCURRENT_REQUESTS", mimir.DefaultMaxConcurrentRequests), "The number of requests in flight to the Mimir API at once, across all workers. 0 disables the limit")
 	flag.BoolVar(&mmConf.TLS.InsecureSkipVerify, "mimir-insecure-skip-verify", getEnv("MIMIR_INSECURE_SKIP_VERIFY", "false") == "true", "Whether to skip TLS verification for the Mimir API")
+
+	// Tracing config
+	flag.StringVar(&tracingOptions.Endpoint, "tracing-endpoint", getEnv("TRACING_ENDPOINT", ""), "The host:port of the OTLP gRPC receiver the traces are sent to. Empty disables tracing")
+	flag.BoolVar(&tracingOptions.Insecure, "tracing-insecure", getEnv("TRACING_INSECURE", "false") == "true", "Whether to send the traces without TLS")
+	flag.Float64Var(&tracingOptions.SampleRatio, "tracing-sample-ratio", getEnvFloat("TRACING_SAMPLE_RATIO", tracing.DefaultSampleRatio), "The ratio of the reconciles traced")
 }
 
 func main() {
 	klog.InitFlags(nil)
 	flag.Parse()
 
+	var file *configLoader
+	if configFile != "" {
+		var err error
+		if file, err = loadConfigFile(flag.CommandLine, configFile); err != nil {
+			fatal(err, "Error reading the config file", "path", configFile)
+		}
+	}
+	if err := setupLogging(logFormat); err != nil {
+		fatal(err, "Error setting up logging")
+	}
+	defer klog.Flush()
+
 	if config.PodName == "" {
-		klog.Fatal("pod-name is required")
+		fatal(nil, "pod-name is required")
 	}
 	if config.PodNamespace == "" {
-		klog.Fatal("pod-namespace is required")
+		fatal(nil, "pod-namespace is required")
 	}
-	if config.LeaseLockNamespace == "" {
-		config.LeaseLockNamespace = config.PodNamespace
+	controllerConfig, err := buildConfig(file)
+	if err != nil {
+		fatal(err, "Invalid configuration")
 	}
 
 	// set up signals, so we handle the first shutdown signal gracefully
 	ctx := contextWithSigterm(context.Background())
 
+	tracingOptions.Instance = controllerConfig.PodName
+	shutdownTracing, err := tracing.Setup(ctx, tracingOptions)
+	if err != nil {
+		fatal(err, "Error setting up tracing")
+	}
+
 	// creates the connection
 	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 	if err != nil {
-		klog.Fatalf("Error building kubeconfig: %s", err.Error())
+		fatal(err, "Error building kubeconfig")
 	}
 
 	// create the clientset
 	kubeClient, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
-		klog.Fatalf("Error building kubernetes clientset: %s", err.Error())
+		fatal(err, "Error building kubernetes clientset")
+	}
+
+	// Identify the cluster by the UID of kube-system, which lives as long as
+	// the cluster
+	if controllerConfig.OwnershipMarkers && controllerConfig.ClusterUID == "" {
+		namespace, err := kubeClient.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+		if err != nil {
+			fatal(err, "Error getting the cluster UID, set it with --cluster-uid")
+		}
+		config.ClusterUID = string(namespace.UID)
+		controllerConfig.ClusterUID = config.ClusterUID
 	}
 
 	// Create the rules clientset
 	rulesClient, err := rulesclientset.NewForConfig(cfg)
 	if err != nil {
-		klog.Fatalf("Error building rules clientset: %s", err.Error())
+		fatal(err, "Error building rules clientset")
 	}
 
 	// Create the mimir client
-	mimirClient, err := client.New(mmConf)
+	mimirClient, err := mimir.New(mmConf, mmOptions)
 	if err != nil {
-		klog.Fatalf("Error building mimir client: %s", err.Error())
+		fatal(err, "Error building mimir client")
 	}
 
 	// Create the rules informer factory
-	rulesInformerFactory := rulesinformers.NewSharedInformerFactory(rulesClient, time.Second*30)
+	rulesInformerFactory := rulesinformers.NewSharedInformerFactoryWithOptions(rulesClient, controllerConfig.ResyncPeriod, controllerConfig.RuleInformerOptions()...)
+
+	// Create the namespace informer factory, only used to match the
+	// namespace selector
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, controllerConfig.ResyncPeriod)
+	var namespaceInformer coreinformers.NamespaceInformer
+	if controllerConfig.NamespaceSelector != "" {
+		namespaceInformer = kubeInformerFactory.Core().V1().Namespaces()
+	}
 
 	metricServer := metrics.New()
+	metricServer.Registry.MustRegister(mimirClient.Collectors()...)
+
+	// Create the manager. The controller campaigns for the shard leases
+	// itself so that losing one does not stop the manager; the shutdown
+	// timeout leaves room for the shards to drain and release their lease.
+	gracefulShutdownTimeout := controllerConfig.ShutdownTimeout + controllerConfig.RenewDeadline
+	mgr, err := manager.New(cfg, manager.Options{
+		Logger:                  klog.NewKlogr(),
+		Metrics:                 metricServer.ServerOptions(address),
+		HealthProbeBindAddress:  probeAddress,
+		GracefulShutdownTimeout: &gracefulShutdownTimeout,
+	})
+	if err != nil {
+		fatal(err, "Error building manager")
+	}
+
 	// Create the ruleController
-	ruleController := controller.NewController(config,
-		kubeClient, rulesClient, mimirClient,
+	ruleController := controller.NewController(controllerConfig,
+		rulesClient, mimirClient,
 		rulesInformerFactory.Rulescontroller().V1alpha1().MimirRules(),
+		namespaceInformer,
 		metricServer.Registry,
 	)
+	if err = ruleController.SetupWithManager(mgr, kubeClient.CoordinationV1()); err != nil {
+		fatal(err, "Error setting up ruleController")
+	}
+	if err = mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		fatal(err, "Error setting up the health checks")
+	}
+	if err = mgr.AddHealthzCheck("workers", ruleController.Workers); err != nil {
+		fatal(err, "Error setting up the health checks")
+	}
+	if err = mgr.AddReadyzCheck("informers", ruleController.InformersSynced); err != nil {
+		fatal(err, "Error setting up the health checks")
+	}
+	if err = mgr.AddReadyzCheck("mimir", ruleController.MimirReachable); err != nil {
+		fatal(err, "Error setting up the health checks")
+	}
+
+	// Apply the changes of the config file without a restart
+	if file != nil {
+		metricServer.Registry.MustRegister(configReloadSuccessGauge, configReloadTimestampGauge)
+		err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			return file.watch(ctx, func() error {
+				next, err := buildConfig(file)
+				if err != nil {
+					return err
+				}
+				return ruleController.UpdateConfig(next)
+			})
+		}))
+		if err != nil {
+			fatal(err, "Error setting up the config file watch")
+		}
+	}
+
+	// Check Mimir right away so a wrong address or credentials show up at
+	// startup rather than as failing rules
+	if err = ruleController.CheckMimir(ctx); err != nil {
+		klog.ErrorS(err, "Error reaching Mimir", "address", mmConf.Address)
+	}
 
 	// runServer the informer factories to begin populating the informer caches
 	rulesInformerFactory.Start(ctx.Done())
+	kubeInformerFactory.Start(ctx.Done())
 
-	lock := &resourcelock.LeaseLock{
-		LeaseMeta: metav1.ObjectMeta{
-			Name:      config.LeaseLockName,
-			Namespace: config.LeaseLockNamespace,
-		},
-		Client: kubeClient.CoordinationV1(),
-		LockConfig: resourcelock.ResourceLockConfig{
-			Identity:      config.Identity(),
-			EventRecorder: nil,
-		},
-	}
-
-	metricServer.Start(ctx, address)
-
-	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
-		Name:          fmt.Sprintf("%s/%s", config.LeaseLockNamespace, config.LeaseLockName),
-		Lock:          lock,
-		LeaseDuration: 15 * time.Second,
-		RenewDeadline: 10 * time.Second,
-		RetryPeriod:   2 * time.Second,
-		Callbacks: leaderelection.LeaderCallbacks{
-			OnStartedLeading: func(ctx context.Context) {
-				// runServer the ruleController
-				klog.Info("Started leading")
-				if err = ruleController.Run(ctx, 2); err != nil {
-					klog.Fatalf("Error running ruleController: %s", err.Error())
-				}
-			},
-			OnStoppedLeading: func() {
-				klog.Fatalf("Stopped leading")
-			},
-			OnNewLeader: func(identity string) {
-				if identity == config.Identity() {
-					return
-				}
-				klog.Infof("New leader elected: %s", identity)
-			},
-		},
-	})
+	klog.InfoS("Starting manager")
+	if err = mgr.Start(ctx); err != nil {
+		fatal(err, "Error running manager")
+	}
+
+	// Flush the spans of the last reconciles
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = shutdownTracing(shutdownCtx); err != nil {
+		klog.ErrorS(err, "Error shutting down tracing")
+	}
+}
+
+// buildConfig returns the controller config of the flags, with the policies
+// of the config file if any.
+func buildConfig(file *configLoader) (controller.Config, error) {
+	c := config
+	if c.LeaseLockNamespace == "" {
+		c.LeaseLockNamespace = c.PodNamespace
+	}
+	c.WatchNamespaces = nil
+	for _, namespace := range strings.Split(watchNamespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			c.WatchNamespaces = append(c.WatchNamespaces, namespace)
+		}
+	}
+	c.Tenant = mmConf.ID
+	if file != nil {
+		c.Policies = file.policies
+	}
+	return c, c.Validate()
 }
 
 // contextWithSigterm returns a context that is cancelled when a SIGTERM is received
@@ -180,3 +319,33 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+		klog.Warningf("Ignoring invalid integer %s=%q", key, value)
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+		klog.Warningf("Ignoring invalid number %s=%q", key, value)
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		klog.Warningf("Ignoring invalid duration %s=%q", key, value)
+	}
+	return fallback
+}
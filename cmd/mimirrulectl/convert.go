@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/mimir/pkg/mimirtool/rules"
+	"github.com/grafana/mimir/pkg/mimirtool/rules/rwrulefmt"
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+// Strategies splitting the rule groups of the Prometheus rule files into
+// MimirRules.
+const (
+	// splitFile converts each rule file into a MimirRule.
+	splitFile = "file"
+	// splitGroup converts each rule group into a MimirRule.
+	splitGroup = "group"
+)
+
+// invalidNameChars are the runs of characters not allowed in the name of a
+// MimirRule.
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// ruleFile is a Prometheus rule file. Its documents are parsed twice: into
+// rule namespaces for their content, and into nodes for their comments.
+type ruleFile struct {
+	path       string
+	namespaces []rules.RuleNamespace
+	nodes      []*yaml.Node
+}
+
+// converted is a MimirRule converted from a rule file, with the comments of
+// the source.
+type converted struct {
+	rule    v1alpha1.MimirRule
+	comment string
+	// groups are the source nodes of the groups of the MimirRule, in order.
+	groups []*yaml.Node
+}
+
+func runConvert(args []string) int {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	split := fs.String("split", splitFile, "How rule groups are split into MimirRules: file, one per rule file, or group, one per rule group")
+	namespace := fs.String("namespace", "default", "The Kubernetes namespace of the MimirRules")
+	controllerName := fs.String("controller-name", "", "The controller name set in the MimirRules")
+	outputDir := fs.String("output-dir", "", "The directory to write a file per MimirRule to, instead of the standard output")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s convert [flags] <file or directory>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *split != splitFile && *split != splitGroup {
+		fmt.Fprintf(os.Stderr, "unknown split strategy %q\n", *split)
+		return 2
+	}
+
+	paths, err := findFiles(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	var results []*converted
+	var errs []error
+	names := map[string]string{}
+	for _, path := range paths {
+		file, err := parseRuleFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		found, err := file.convert(*split)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, c := range found {
+			c.rule.Namespace = *namespace
+			c.rule.Spec.ControllerName = *controllerName
+			if previous, ok := names[c.rule.Name]; ok {
+				errs = append(errs, fmt.Errorf("%s: MimirRule name '%s' is also converted from %s", path, c.rule.Name, previous))
+				continue
+			}
+			names[c.rule.Name] = path
+			results = append(results, c)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *outputDir == "" {
+		err = writeManifests(os.Stdout, results)
+	} else {
+		err = writeManifestFiles(*outputDir, results)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// parseRuleFile reads a Prometheus rule file. The mimirtool format, with a
+// namespace and remote write configs, is accepted as well.
+func parseRuleFile(path string) (*ruleFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, errs := rules.ParseBytes(data)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %w", path, errors.Join(errs...))
+	}
+
+	file := &ruleFile{path: path, namespaces: namespaces}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		node := &yaml.Node{}
+		if err := decoder.Decode(node); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		file.nodes = append(file.nodes, node)
+	}
+	if len(file.nodes) != len(file.namespaces) {
+		return nil, fmt.Errorf("%s: found %d documents, parsed %d", path, len(file.nodes), len(file.namespaces))
+	}
+	return file, nil
+}
+
+// convert converts the rule groups of the file into MimirRules according to
+// the split strategy.
+func (f *ruleFile) convert(split string) ([]*converted, error) {
+	base := strings.TrimSuffix(filepath.Base(f.path), filepath.Ext(f.path))
+
+	var results []*converted
+	var errs []error
+	whole := &converted{rule: newMimirRule(base), comment: f.comment()}
+	seen := map[string]bool{}
+	for i, ns := range f.namespaces {
+		groupNodes := sequence(mappingValue(document(f.nodes[i]), "groups"))
+		for j, group := range ns.Groups {
+			ruleGroup, err := convertGroup(group)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s[%d]: group %q: %w", f.path, i, group.Name, err))
+				continue
+			}
+			var node *yaml.Node
+			if j < len(groupNodes) {
+				node = groupNodes[j]
+			}
+			// The documents of a file may repeat a group name, which neither a
+			// Mimir namespace nor the MimirRule names of the groups allow.
+			// Within a document, rules.ParseBytes already rejected it.
+			if seen[group.Name] {
+				errs = append(errs, fmt.Errorf("%s[%d]: group %q is repeated in another document of the file", f.path, i, group.Name))
+				continue
+			}
+			seen[group.Name] = true
+			if split == splitGroup {
+				// Every MimirRule may be written to its own file, each keeps
+				// the comment heading the rule file
+				c := &converted{rule: newMimirRule(base + "-" + group.Name), comment: f.comment(), groups: []*yaml.Node{node}}
+				c.rule.Spec.Groups = append(c.rule.Spec.Groups, ruleGroup)
+				results = append(results, c)
+				continue
+			}
+			whole.rule.Spec.Groups = append(whole.rule.Spec.Groups, ruleGroup)
+			whole.groups = append(whole.groups, node)
+		}
+	}
+	if split == splitFile && len(whole.rule.Spec.Groups) > 0 {
+		results = append(results, whole)
+	}
+	for _, c := range results {
+		for _, msg := range validation.IsDNS1123Subdomain(c.rule.Name) {
+			errs = append(errs, fmt.Errorf("%s: invalid MimirRule name '%s': %s", f.path, c.rule.Name, msg))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// comment returns the comment heading the first document of the file.
+func (f *ruleFile) comment() string {
+	if len(f.nodes) == 0 {
+		return ""
+	}
+	var comments []string
+	if f.nodes[0].HeadComment != "" {
+		comments = append(comments, f.nodes[0].HeadComment)
+	}
+	if root := document(f.nodes[0]); root != nil && root.Kind == yaml.MappingNode && len(root.Content) > 0 {
+		if c := root.Content[0].HeadComment; c != "" {
+			comments = append(comments, c)
+		}
+	}
+	return strings.Join(comments, "\n\n")
+}
+
+func newMimirRule(name string) v1alpha1.MimirRule {
+	return v1alpha1.MimirRule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			Kind:       "MimirRule",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(name), "-"), "-"),
+		},
+	}
+}
+
+// convertGroup converts a rule group, including the fields specific to
+// Mimir. Fields a MimirRule cannot carry are reported rather than dropped.
+func convertGroup(group rwrulefmt.RuleGroup) (v1alpha1.RuleGroup, error) {
+	converted := v1alpha1.RuleGroup{
+		Name:          group.Name,
+		Limit:         group.Limit,
+		SourceTenants: group.SourceTenants,
+	}
+	var errs []error
+	if len(group.RWConfigs) > 0 {
+		errs = append(errs, errors.New("remote_write is not supported by MimirRule"))
+	}
+	if group.AlignEvaluationTimeOnInterval {
+		errs = append(errs, errors.New("align_evaluation_time_on_interval is not supported by MimirRule"))
+	}
+	if group.Interval != 0 {
+		converted.Interval = group.Interval.String()
+	}
+	if group.EvaluationDelay != nil {
+		converted.EvaluationDelay = group.EvaluationDelay.String()
+	}
+
+	converted.Rules = make([]v1alpha1.Rule, 0, len(group.Rules))
+	for i, rule := range group.Rules {
+		if rule.KeepFiringFor != 0 {
+			errs = append(errs, fmt.Errorf("rule %d: keep_firing_for is not supported by MimirRule", i))
+		}
+		r := v1alpha1.Rule{
+			Record:      rule.Record.Value,
+			Alert:       rule.Alert.Value,
+			Expr:        intstr.FromString(rule.Expr.Value),
+			Labels:      rule.Labels,
+			Annotations: rule.Annotations,
+		}
+		if rule.For != 0 {
+			r.For = v1alpha1.Duration(rule.For.String())
+		}
+		converted.Rules = append(converted.Rules, r)
+	}
+	return converted, errors.Join(errs...)
+}
+
+// node returns the YAML document of the MimirRule, with the comments of the
+// source groups copied onto the converted ones.
+func (c *converted) node() (*yaml.Node, error) {
+	data, err := json.Marshal(&c.rule)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML in flow style
+	doc := &yaml.Node{}
+	if err := yaml.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	root := document(doc)
+	blockStyle(root)
+	deleteKey(root, "status")
+	deleteKey(mappingValue(root, "metadata"), "creationTimestamp")
+	// Write apiVersion first, like kubectl
+	if len(root.Content) >= 4 && root.Content[0].Value == "kind" {
+		root.Content[0], root.Content[1], root.Content[2], root.Content[3] = root.Content[2], root.Content[3], root.Content[0], root.Content[1]
+	}
+
+	doc.HeadComment = c.comment
+	groups := sequence(mappingValue(mappingValue(root, "spec"), "groups"))
+	for i, src := range c.groups {
+		if src != nil && i < len(groups) {
+			copyComments(src, groups[i])
+		}
+	}
+	return doc, nil
+}
+
+func writeManifests(w io.Writer, results []*converted) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	for _, c := range results {
+		doc, err := c.node()
+		if err != nil {
+			return fmt.Errorf("MimirRule '%s': %w", c.rule.Name, err)
+		}
+		if err := encoder.Encode(doc); err != nil {
+			return fmt.Errorf("MimirRule '%s': %w", c.rule.Name, err)
+		}
+	}
+	return encoder.Close()
+}
+
+func writeManifestFiles(dir string, results []*converted) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, c := range results {
+		var buf bytes.Buffer
+		if err := writeManifests(&buf, []*converted{c}); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, c.rule.Name+".yaml"), buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// document returns the content of a document node.
+func document(node *yaml.Node) *yaml.Node {
+	if node != nil && node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0]
+	}
+	return node
+}
+
+// mappingValue returns the value of key in a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// deleteKey removes key from a mapping node.
+func deleteKey(node *yaml.Node, key string) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// sequence returns the items of a sequence node, or nil.
+func sequence(node *yaml.Node) []*yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return node.Content
+}
+
+// blockStyle resets the style of the nodes decoded from JSON, so that they
+// are written in block style with quotes only where needed.
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "\n") {
+		node.Style = yaml.LiteralStyle
+	}
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
+
+// copyComments copies the comments of src onto dst, matching the keys of
+// mappings and the items of sequences. The converted groups use the keys of
+// the Prometheus format, so the comments land next to the same fields.
+func copyComments(src, dst *yaml.Node) {
+	dst.HeadComment = src.HeadComment
+	dst.LineComment = src.LineComment
+	dst.FootComment = src.FootComment
+	switch {
+	case src.Kind == yaml.MappingNode && dst.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			for j := 0; j+1 < len(dst.Content); j += 2 {
+				if src.Content[i].Value == dst.Content[j].Value {
+					copyComments(src.Content[i], dst.Content[j])
+					copyComments(src.Content[i+1], dst.Content[j+1])
+					break
+				}
+			}
+		}
+	case src.Kind == yaml.SequenceNode && dst.Kind == yaml.SequenceNode:
+		for i := 0; i < len(src.Content) && i < len(dst.Content); i++ {
+			copyComments(src.Content[i], dst.Content[i])
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const convertHeader = "# Rules of the team\n"
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		split     string
+		wantNames []string
+		wantErr   string
+	}{
+		{
+			name:      "file",
+			data:      convertHeader + "groups:\n- name: a\n  rules:\n  - record: x\n    expr: up\n- name: b\n  rules:\n  - record: y\n    expr: up\n",
+			split:     splitFile,
+			wantNames: []string{"rules"},
+		},
+		{
+			name:      "group",
+			data:      convertHeader + "groups:\n- name: a\n  rules:\n  - record: x\n    expr: up\n- name: b\n  rules:\n  - record: y\n    expr: up\n",
+			split:     splitGroup,
+			wantNames: []string{"rules-a", "rules-b"},
+		},
+		{
+			name:    "repeated in a document",
+			data:    "groups:\n- name: a\n  rules:\n  - record: x\n    expr: up\n- name: a\n  rules:\n  - record: y\n    expr: up\n",
+			split:   splitGroup,
+			wantErr: `"a" is repeated`,
+		},
+		{
+			name:    "repeated across documents",
+			data:    "groups:\n- name: a\n  rules:\n  - record: x\n    expr: up\n---\ngroups:\n- name: a\n  rules:\n  - record: y\n    expr: up\n",
+			split:   splitFile,
+			wantErr: `group "a" is repeated in another document of the file`,
+		},
+		{
+			name:    "repeated across documents, split by group",
+			data:    "groups:\n- name: a\n  rules:\n  - record: x\n    expr: up\n---\ngroups:\n- name: a\n  rules:\n  - record: y\n    expr: up\n",
+			split:   splitGroup,
+			wantErr: `group "a" is repeated in another document of the file`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.yaml")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			results, err := convertFile(path, tt.split)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("convert() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("convert() error = %v", err)
+			}
+			if len(results) != len(tt.wantNames) {
+				t.Fatalf("convert() returned %d MimirRules, want %d", len(results), len(tt.wantNames))
+			}
+			for i, c := range results {
+				if c.rule.Name != tt.wantNames[i] {
+					t.Errorf("MimirRule %d is named %q, want %q", i, c.rule.Name, tt.wantNames[i])
+				}
+				var out strings.Builder
+				if err := writeManifests(&out, []*converted{c}); err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(out.String(), convertHeader) {
+					t.Errorf("MimirRule %q lost the comment of the file:\n%s", c.rule.Name, out.String())
+				}
+			}
+		})
+	}
+}
+
+func convertFile(path, split string) ([]*converted, error) {
+	file, err := parseRuleFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return file.convert(split)
+}
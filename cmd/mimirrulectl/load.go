@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+// manifest is a MimirRule read from a file.
+type manifest struct {
+	// Path is the file the MimirRule was read from.
+	Path string
+	// Index is the position of the document in the file.
+	Index int
+	Rule  v1alpha1.MimirRule
+}
+
+// Source returns the location of the manifest, used to annotate errors.
+func (m *manifest) Source() string {
+	return fmt.Sprintf("%s[%d] %s/%s", m.Path, m.Index, m.Rule.Namespace, m.Rule.Name)
+}
+
+// loadManifests reads the MimirRule manifests of the given files and
+// directories. Directories are walked recursively for YAML and JSON files and
+// documents of other kinds are skipped.
+func loadManifests(paths []string) ([]*manifest, error) {
+	files, err := findFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []*manifest
+	for _, path := range files {
+		found, err := loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, found...)
+	}
+	return manifests, nil
+}
+
+// findFiles returns the given files and the YAML and JSON files found by
+// walking the given directories recursively, in lexical order.
+func findFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if path != root && !isManifestFile(path) {
+				return nil
+			}
+			files = append(files, path)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func isManifestFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+func loadFile(path string) ([]*manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var manifests []*manifest
+	decoder := yaml.NewYAMLOrJSONDecoder(f, 4096)
+	for i := 0; ; i++ {
+		m := &manifest{Path: path, Index: i}
+		if err := decoder.Decode(&m.Rule); err != nil {
+			if errors.Is(err, io.EOF) {
+				return manifests, nil
+			}
+			return nil, fmt.Errorf("%s[%d]: %w", path, i, err)
+		}
+		if m.Rule.Kind != "MimirRule" {
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+}
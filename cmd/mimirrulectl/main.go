@@ -0,0 +1,47 @@
+// Command mimirrulectl works with MimirRule manifests offline, e.g. in CI
+// pipelines, running the same checks as the controller.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{name: "test", usage: "Run the unit tests of MimirRule manifests", run: runTest},
+	{name: "validate", usage: "Check MimirRule manifests like the controller does", run: runValidate},
+	{name: "convert", usage: "Convert Prometheus rule files into MimirRule manifests", run: runConvert},
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == flag.Arg(0) {
+			os.Exit(cmd.run(flag.Args()[1:]))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] <file or directory>...\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/ruletest"
+)
+
+func runTest(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s test <file or directory>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	manifests, err := loadManifests(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	failed := false
+	for _, m := range manifests {
+		if len(m.Rule.Spec.Tests) == 0 {
+			continue
+		}
+		ns, err := m.Rule.Spec.GetMimirRuleNamespace(m.Rule.Namespace + ":" + m.Rule.Name)
+		if err != nil {
+			fmt.Printf("FAILED %s: %s\n", m.Source(), err)
+			failed = true
+			continue
+		}
+		if errs := ruletest.Run(context.Background(), ns, m.Rule.Spec.Tests, ruletest.DefaultLimits); len(errs) > 0 {
+			fmt.Printf("FAILED %s:\n", m.Source())
+			for _, err := range errs {
+				fmt.Printf("  %s\n", err)
+			}
+			failed = true
+			continue
+		}
+		fmt.Printf("SUCCESS %s: %d tests passed\n", m.Source(), len(m.Rule.Spec.Tests))
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
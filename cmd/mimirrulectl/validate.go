@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/controller"
+	"github.com/healthjoy/mimir-rules-controller/pkg/ruletest"
+	"github.com/healthjoy/mimir-rules-controller/pkg/validation"
+)
+
+// Output formats of the validate command.
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatJUnit = "junit"
+)
+
+// checkError is an error found by one of the checks of the controller.
+type checkError struct {
+	// Check names the failed check, matching the reason the controller
+	// reports for it.
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// validationResult is the outcome of the checks of a manifest.
+type validationResult struct {
+	Path      string       `json:"path"`
+	Index     int          `json:"index"`
+	Namespace string       `json:"namespace"`
+	Name      string       `json:"name"`
+	Source    string       `json:"-"`
+	Valid     bool         `json:"valid"`
+	Errors    []checkError `json:"errors,omitempty"`
+}
+
+func runValidate(args []string) int {
+	return validateCommand(args, os.Stdout, os.Stderr)
+}
+
+// validateCommand runs the validate command, writing the report to stdout,
+// and returns its exit status: 0 when all manifests are valid, 1 when one is
+// not or cannot be read and 2 on usage errors.
+func validateCommand(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", formatText, "The output format: text, json or junit")
+	// Only the naming settings are used offline, the others are the defaults
+	// of the controller so that the configuration validates
+	config := controller.Config{
+		DefaultController:    true,
+		Workers:              controller.DefaultWorkers,
+		GroupConcurrency:     controller.DefaultGroupConcurrency,
+		LeaseDuration:        controller.DefaultLeaseDuration,
+		RenewDeadline:        controller.DefaultRenewDeadline,
+		RetryPeriod:          controller.DefaultRetryPeriod,
+		Shards:               controller.DefaultShards,
+		RateLimiterBaseDelay: controller.DefaultRateLimiterBaseDelay,
+		RateLimiterMaxDelay:  controller.DefaultRateLimiterMaxDelay,
+		RateLimiterQPS:       controller.DefaultRateLimiterQPS,
+		RateLimiterBurst:     controller.DefaultRateLimiterBurst,
+	}
+	fs.StringVar(&config.ClusterName, "cluster-name", "default", "The cluster name used to render the Mimir namespace")
+	fs.StringVar(&config.NamespaceTemplate, "mimir-namespace-template", controller.DefaultNamespaceTemplate, "The Go template used to name the Mimir namespace of a MimirRule")
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s validate [flags] <file or directory>...\n\n", os.Args[0])
+		fmt.Fprintf(stderr, "Runs the checks of the controller, including the unit tests of spec.tests.\n\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	switch *output {
+	case formatText, formatJSON, formatJUnit:
+	default:
+		fmt.Fprintf(stderr, "unknown output format %q\n", *output)
+		return 2
+	}
+	if err := config.Validate(); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	manifests, err := loadManifests(fs.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	results := make([]validationResult, 0, len(manifests))
+	failed := false
+	for _, m := range manifests {
+		result := validationResult{
+			Path:      m.Path,
+			Index:     m.Index,
+			Namespace: m.Rule.Namespace,
+			Name:      m.Rule.Name,
+			Source:    m.Source(),
+			Errors:    validate(&config, m),
+		}
+		result.Valid = len(result.Errors) == 0
+		failed = failed || !result.Valid
+		results = append(results, result)
+	}
+
+	switch *output {
+	case formatJSON:
+		err = writeJSON(stdout, results)
+	case formatJUnit:
+		err = writeJUnit(stdout, results)
+	default:
+		writeText(stdout, results)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// validate runs the checks of the controller on the MimirRule of m, in the
+// same order, and stops at the first failed check like the controller does.
+func validate(config *controller.Config, m *manifest) []checkError {
+	mimirNamespace, err := config.MimirNamespace(&m.Rule)
+	if err != nil {
+		return []checkError{{Check: controller.ReasonInvalidNamespace, Message: err.Error()}}
+	}
+	ns, err := m.Rule.Spec.GetMimirRuleNamespace(mimirNamespace)
+	if err != nil {
+		return []checkError{{Check: controller.ReasonInvalidSpec, Message: err.Error()}}
+	}
+	for _, group := range ns.Groups {
+		if group.Name == controller.OwnerGroup {
+			return []checkError{{Check: controller.ReasonInvalidSpec, Message: fmt.Sprintf("rule group name '%s' is reserved", controller.OwnerGroup)}}
+		}
+	}
+	if errs := ns.Validate(); len(errs) > 0 {
+		return checkErrors(controller.ReasonValidationFailed, errs)
+	}
+	if errs := validation.Templates(ns); len(errs) > 0 {
+		return checkErrors(controller.ReasonInvalidTemplates, errs)
+	}
+	if _, _, err := ns.LintExpressions("mimir"); err != nil {
+		return []checkError{{Check: controller.ReasonLintFailed, Message: err.Error()}}
+	}
+	if len(m.Rule.Spec.Tests) > 0 {
+		if errs := ruletest.Run(context.Background(), ns, m.Rule.Spec.Tests, ruletest.DefaultLimits); len(errs) > 0 {
+			return checkErrors(controller.ReasonTestsFailed, errs)
+		}
+	}
+	return nil
+}
+
+func checkErrors(check string, errs []error) []checkError {
+	found := make([]checkError, 0, len(errs))
+	for _, err := range errs {
+		found = append(found, checkError{Check: check, Message: err.Error()})
+	}
+	return found
+}
+
+func writeText(w io.Writer, results []validationResult) {
+	for _, result := range results {
+		if result.Valid {
+			fmt.Fprintf(w, "SUCCESS %s\n", result.Source)
+			continue
+		}
+		fmt.Fprintf(w, "FAILED %s:\n", result.Source)
+		for _, err := range result.Errors {
+			fmt.Fprintf(w, "  %s: %s\n", err.Check, indent(err.Message))
+		}
+	}
+}
+
+// indent aligns the continuation lines of multi-line messages.
+func indent(message string) string {
+	return strings.ReplaceAll(message, "\n", "\n    ")
+}
+
+func writeJSON(w io.Writer, results []validationResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+// JUnit XML report, as read by CI systems: a test case per manifest.
+type (
+	junitTestSuites struct {
+		XMLName xml.Name     `xml:"testsuites"`
+		Suites  []junitSuite `xml:"testsuite"`
+	}
+	junitSuite struct {
+		Name     string          `xml:"name,attr"`
+		Tests    int             `xml:"tests,attr"`
+		Failures int             `xml:"failures,attr"`
+		Cases    []junitTestCase `xml:"testcase"`
+	}
+	junitTestCase struct {
+		Name      string        `xml:"name,attr"`
+		Classname string        `xml:"classname,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+	}
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Type    string `xml:"type,attr"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+func writeJUnit(w io.Writer, results []validationResult) error {
+	suite := junitSuite{Name: "mimirrulectl validate", Tests: len(results)}
+	for _, result := range results {
+		testCase := junitTestCase{
+			Name:      fmt.Sprintf("%s/%s", result.Namespace, result.Name),
+			Classname: fmt.Sprintf("%s[%d]", result.Path, result.Index),
+		}
+		if !result.Valid {
+			suite.Failures++
+			var text []string
+			for _, err := range result.Errors {
+				text = append(text, err.Check+": "+err.Message)
+			}
+			testCase.Failure = &junitFailure{
+				Message: result.Errors[0].Message,
+				Type:    result.Errors[0].Check,
+				Text:    strings.Join(text, "\n"),
+			}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitTestSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the reports")
+
+func TestValidate(t *testing.T) {
+	const manifests = "testdata/validate/rules.yaml"
+	tests := []struct {
+		name string
+		args []string
+		// golden is the file holding the expected report, if any
+		golden     string
+		wantStderr string
+		wantCode   int
+	}{
+		{name: "text", args: []string{manifests}, golden: "report.txt", wantCode: 1},
+		{name: "json", args: []string{"-output=json", manifests}, golden: "report.json", wantCode: 1},
+		{name: "junit", args: []string{"-output=junit", manifests}, golden: "report.xml", wantCode: 1},
+		{name: "valid", args: []string{"../../examples"}, golden: "examples.txt"},
+		{name: "missing file", args: []string{"testdata/validate/missing.yaml"}, wantStderr: "no such file or directory", wantCode: 1},
+		{name: "no file", wantStderr: "Usage:", wantCode: 2},
+		{name: "unknown output", args: []string{"-output=yaml", manifests}, wantStderr: `unknown output format "yaml"`, wantCode: 2},
+		{
+			name:       "invalid namespace template",
+			args:       []string{"-mimir-namespace-template={{.Team}}", manifests},
+			wantStderr: "invalid namespace template",
+			wantCode:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr strings.Builder
+			if code := validateCommand(tt.args, &stdout, &stderr); code != tt.wantCode {
+				t.Errorf("validate exited with %d, want %d, stderr:\n%s", code, tt.wantCode, stderr.String())
+			}
+			if tt.wantStderr != "" {
+				if !strings.Contains(stderr.String(), tt.wantStderr) {
+					t.Errorf("stderr = %q, want %q", stderr.String(), tt.wantStderr)
+				}
+				if stdout.Len() > 0 {
+					t.Errorf("stdout = %q, want none", stdout.String())
+				}
+			}
+			if tt.golden == "" {
+				return
+			}
+			path := filepath.Join("testdata", "validate", tt.golden)
+			if *update {
+				if err := os.WriteFile(path, []byte(stdout.String()), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stdout.String() != string(want) {
+				t.Errorf("report differs from %s, run go test -update:\n%s", path, stdout.String())
+			}
+		})
+	}
+}
@@ -1,4 +1,4 @@
 // +k8s:deepcopy-gen=package
-// +groupName=rulescontroller.mimir.grafana.com
+// +groupName=rulescontroller.k8s.healthjoy.com
 
 package v1alpha1
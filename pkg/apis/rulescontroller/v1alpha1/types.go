@@ -21,6 +21,10 @@ const (
 	ConditionTypeReady ConditionType = "Ready"
 	// ConditionTypeFailed means the condition is in failed state
 	ConditionTypeFailed ConditionType = "Failed"
+	// ConditionTypeConflict means the rule conflicts with other rules
+	ConditionTypeConflict ConditionType = "Conflict"
+	// ConditionTypeTested means the unit tests of the rule passed
+	ConditionTypeTested ConditionType = "Tested"
 )
 
 const (
@@ -42,7 +46,12 @@ type MimirRule struct {
 
 // RuleSpec is the spec for a MimirRule resource
 type RuleSpec struct {
-	Groups []RuleGroup `json:"groups"`
+	// ControllerName is the name of the controller instance managing the
+	// rule. Rules without one are managed by the default controller.
+	ControllerName string      `json:"controllerName,omitempty"`
+	Groups         []RuleGroup `json:"groups"`
+	// Tests are unit tests run against the groups before they are pushed.
+	Tests []RuleTest `json:"tests,omitempty"`
 }
 
 // RuleGroup is a list of sequentially evaluated recording and alerting rules.
@@ -65,9 +74,60 @@ type Rule struct {
 	Annotations map[string]string  `json:"annotations,omitempty"`
 }
 
+// RuleTest is a unit test of the rules of a MimirRule, following the format
+// of promtool rule unit tests.
+type RuleTest struct {
+	Name            string            `json:"name,omitempty"`
+	Interval        Duration          `json:"interval,omitempty"`
+	InputSeries     []InputSeries     `json:"input_series,omitempty"`
+	AlertRuleTests  []AlertRuleTest   `json:"alert_rule_test,omitempty"`
+	PromQLExprTests []PromQLExprTest  `json:"promql_expr_test,omitempty"`
+	ExternalLabels  map[string]string `json:"external_labels,omitempty"`
+	ExternalURL     string            `json:"external_url,omitempty"`
+}
+
+// InputSeries is a series loaded before the rules are evaluated, with values
+// in the expanding notation of promtool, e.g. '0+10x100'.
+type InputSeries struct {
+	Series string `json:"series"`
+	Values string `json:"values"`
+}
+
+// AlertRuleTest checks the alerts firing at a given time.
+type AlertRuleTest struct {
+	EvalTime  Duration        `json:"eval_time"`
+	Alertname string          `json:"alertname"`
+	ExpAlerts []ExpectedAlert `json:"exp_alerts,omitempty"`
+}
+
+// ExpectedAlert is an alert expected to fire.
+type ExpectedAlert struct {
+	ExpLabels      map[string]string `json:"exp_labels,omitempty"`
+	ExpAnnotations map[string]string `json:"exp_annotations,omitempty"`
+}
+
+// PromQLExprTest checks the result of a PromQL expression at a given time.
+type PromQLExprTest struct {
+	Expr       string           `json:"expr"`
+	EvalTime   Duration         `json:"eval_time"`
+	ExpSamples []ExpectedSample `json:"exp_samples,omitempty"`
+}
+
+// ExpectedSample is a sample expected in the result of a PromQL expression.
+type ExpectedSample struct {
+	Labels string  `json:"labels,omitempty"`
+	Value  float64 `json:"value"`
+}
+
 // RuleStatus is the status for a MimirRule resource
 type RuleStatus struct {
 	Conditions []metav1.Condition `json:"conditions"`
+	// MimirNamespace is the Mimir namespace the rule groups were last pushed to.
+	MimirNamespace string `json:"mimirNamespace,omitempty"`
+	// Groups are the names of the rule groups last pushed to MimirNamespace,
+	// as named in Mimir. When the MimirRules of a Kubernetes namespace are
+	// aggregated they are prefixed with the name of the MimirRule.
+	Groups []string `json:"groups,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -100,10 +160,11 @@ func (mr *RuleSpec) GetMimirRuleNamespace(namespace string) (ruleNs *rules.RuleN
 		}
 
 		if group.EvaluationDelay != "" {
-			*groupPtr.EvaluationDelay, err = model.ParseDuration(group.EvaluationDelay)
+			evaluationDelay, err := model.ParseDuration(group.EvaluationDelay)
 			if err != nil {
 				return nil, err
 			}
+			groupPtr.EvaluationDelay = &evaluationDelay
 		}
 
 		groupPtr.Rules = make([]rulefmt.RuleNode, len(group.Rules))
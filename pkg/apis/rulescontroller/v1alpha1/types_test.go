@@ -0,0 +1,41 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetMimirRuleNamespaceEvaluationDelay(t *testing.T) {
+	tests := []struct {
+		name            string
+		evaluationDelay string
+		// want is the expected delay, nil when unset
+		want    *time.Duration
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "set", evaluationDelay: "30s", want: func() *time.Duration { d := 30 * time.Second; return &d }()},
+		{name: "invalid", evaluationDelay: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := RuleSpec{Groups: []RuleGroup{{Name: "group", EvaluationDelay: tt.evaluationDelay}}}
+			ns, err := spec.GetMimirRuleNamespace("namespace")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetMimirRuleNamespace() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := ns.Groups[0].EvaluationDelay
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("EvaluationDelay = %s, want nil", *got)
+			case tt.want != nil && got == nil:
+				t.Errorf("EvaluationDelay = nil, want %s", *tt.want)
+			case tt.want != nil && time.Duration(*got) != *tt.want:
+				t.Errorf("EvaluationDelay = %s, want %s", *got, *tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/util/workqueue"
+
+	rulesinformers "github.com/healthjoy/mimir-rules-controller/pkg/generated/informers/externalversions"
+)
+
+// Config is the configuration for the controller.
+type Config struct {
+	// ClusterName is the name of the cluster and use as the first part of the
+	// Namespace of RuleNamespace in Mimir
+	ClusterName string
+	// ClusterUID identifies the cluster in the owner group of the Mimir
+	// namespaces, telling apart clusters started with the same ClusterName.
+	ClusterUID string
+	// OwnershipMarkers enables the owner group of the Mimir namespaces, see
+	// OwnerGroup.
+	OwnershipMarkers bool
+	// PodName is the name of the pod running the controller.
+	PodName string
+	// PodNamespace is the namespace of the pod running the controller.
+	PodNamespace string
+	// LeaseLockName is the name of the lease lock.
+	LeaseLockName string
+	// LeaseLockNamespace is the namespace of the lease lock.
+	LeaseLockNamespace string
+	// NamespaceTemplate is the Go template used to build the name of the
+	// Mimir namespace of a MimirRule, see NamespaceTemplateData for the
+	// available fields. Defaults to DefaultNamespaceTemplate.
+	NamespaceTemplate string
+	// DetectDuplicateAlerts enables reporting alert names used by more than
+	// one MimirRule in the same Kubernetes namespace.
+	DetectDuplicateAlerts bool
+	// MaxRulesPerRuleGroup is the maximum number of rules in a rule group,
+	// mirroring the ruler_max_rules_per_rule_group limit of Mimir. Zero
+	// disables the check.
+	MaxRulesPerRuleGroup int
+	// MaxRuleGroupsPerTenant is the maximum number of rule groups of the
+	// tenant, mirroring the ruler_max_rule_groups_per_tenant limit of Mimir.
+	// Zero disables the check.
+	MaxRuleGroupsPerTenant int
+	// RulerLimitsRefreshInterval is the interval at which the ruler limits are
+	// fetched from the Mimir user limits API, overriding the configured ones.
+	// Zero disables fetching.
+	RulerLimitsRefreshInterval time.Duration
+	// ControllerName is the name matched against spec.controllerName of the
+	// MimirRules to find the ones addressed to this controller instance.
+	ControllerName string
+	// DefaultController makes the controller manage the MimirRules without a
+	// controllerName as well.
+	DefaultController bool
+	// MimirCheckInterval is the interval at which the connectivity to the
+	// Mimir ruler API is checked. Rules are paused while the check fails.
+	// Zero disables the periodic check.
+	MimirCheckInterval time.Duration
+	// WatchNamespaces are the namespaces of the MimirRules managed by the
+	// controller. Empty means all namespaces.
+	WatchNamespaces []string
+	// NamespaceSelector is a label selector the namespace of a MimirRule must
+	// match for the rule to be managed by the controller.
+	NamespaceSelector string
+	// RuleSelector is a label selector a MimirRule must match to be managed by
+	// the controller.
+	RuleSelector string
+	// UnselectedPolicy tells what happens to the rule groups of a MimirRule
+	// that stops matching the selectors, see UnselectedPolicyDelete and
+	// UnselectedPolicyRetain.
+	UnselectedPolicy string
+	// AggregateNamespaces merges the MimirRules of a Kubernetes namespace into
+	// a single Mimir namespace named "<cluster>:<namespace>", ignoring
+	// NamespaceTemplate. The rule groups are prefixed with the name of their
+	// MimirRule to keep them apart.
+	AggregateNamespaces bool
+	// Tenant is the Mimir tenant the rule groups are pushed to, matched by
+	// the tenants of the policies.
+	Tenant string
+	// Policies override the settings above for some of the MimirRules.
+	Policies []Policy
+
+	// Workers is the number of rules processed concurrently.
+	Workers int
+	// GroupConcurrency is the number of rule groups of a rule pushed to Mimir
+	// concurrently.
+	GroupConcurrency int
+	// ResyncPeriod is the resync period of the informers.
+	ResyncPeriod time.Duration
+	// LeaseDuration is the duration non-leader candidates wait before trying
+	// to acquire the lease.
+	LeaseDuration time.Duration
+	// RenewDeadline is the duration the leader retries refreshing the lease
+	// before giving up.
+	RenewDeadline time.Duration
+	// RetryPeriod is the duration candidates wait between tries of actions.
+	RetryPeriod time.Duration
+	// WorkerStallTimeout is how long rules may wait in the work queue without
+	// any worker finishing a rule before the liveness check fails. Zero
+	// disables the check.
+	WorkerStallTimeout time.Duration
+	// Shards is the number of shards the MimirRule keys are hashed into. Each
+	// shard is owned by the replica holding its Lease, so up to Shards
+	// replicas reconcile rules in parallel.
+	Shards int
+	// ShutdownTimeout is how long in-flight rules keep running once the
+	// controller stops, on shutdown or after losing the lease, before their
+	// Mimir calls are cancelled.
+	ShutdownTimeout time.Duration
+	// RateLimiterBaseDelay is the first delay of the per-item exponential
+	// backoff of failed rules.
+	RateLimiterBaseDelay time.Duration
+	// RateLimiterMaxDelay is the maximum delay of the per-item exponential
+	// backoff of failed rules.
+	RateLimiterMaxDelay time.Duration
+	// RateLimiterQPS is the overall rate of rules added to the work queue.
+	RateLimiterQPS float64
+	// RateLimiterBurst is the overall burst of rules added to the work queue.
+	RateLimiterBurst int
+
+	identity          string
+	namespaceTemplate *template.Template
+	namespaceSelector labels.Selector
+	ruleSelector      labels.Selector
+}
+
+// DefaultControllerName is the controller name used when none is configured.
+const DefaultControllerName = "k8s.healthjoy.com/mimir-rules-controller"
+
+const (
+	// UnselectedPolicyDelete deletes the rule groups of a MimirRule from
+	// Mimir when it stops matching the selectors.
+	UnselectedPolicyDelete = "delete"
+	// UnselectedPolicyRetain leaves the rule groups of a MimirRule in Mimir
+	// when it stops matching the selectors.
+	UnselectedPolicyRetain = "retain"
+)
+
+// Defaults of the runtime parameters, matching client-go defaults.
+const (
+	DefaultWorkers              = 2
+	DefaultGroupConcurrency     = 4
+	DefaultResyncPeriod         = 30 * time.Second
+	DefaultLeaseDuration        = 15 * time.Second
+	DefaultRenewDeadline        = 10 * time.Second
+	DefaultRetryPeriod          = 2 * time.Second
+	DefaultShards               = 1
+	DefaultMimirCheckInterval   = 30 * time.Second
+	DefaultWorkerStallTimeout   = 10 * time.Minute
+	DefaultShutdownTimeout      = 15 * time.Second
+	DefaultRateLimiterBaseDelay = 5 * time.Millisecond
+	DefaultRateLimiterMaxDelay  = 1000 * time.Second
+	DefaultRateLimiterQPS       = 10
+	DefaultRateLimiterBurst     = 100
+)
+
+// Validate checks the configuration and prepares it for use.
+func (c *Config) Validate() error {
+	var (
+		errs []error
+		err  error
+	)
+	if c.Workers < 1 {
+		errs = append(errs, fmt.Errorf("workers must be at least 1, got %d", c.Workers))
+	}
+	if c.GroupConcurrency < 1 {
+		errs = append(errs, fmt.Errorf("group concurrency must be at least 1, got %d", c.GroupConcurrency))
+	}
+	if c.ResyncPeriod < 0 {
+		errs = append(errs, fmt.Errorf("resync period must not be negative, got %s", c.ResyncPeriod))
+	}
+	if c.LeaseDuration <= c.RenewDeadline {
+		errs = append(errs, fmt.Errorf("lease duration (%s) must be greater than renew deadline (%s)", c.LeaseDuration, c.RenewDeadline))
+	}
+	if c.RenewDeadline <= time.Duration(leaderelection.JitterFactor*float64(c.RetryPeriod)) {
+		errs = append(errs, fmt.Errorf("renew deadline (%s) must be greater than retry period (%s) * %.1f", c.RenewDeadline, c.RetryPeriod, leaderelection.JitterFactor))
+	}
+	if c.RetryPeriod <= 0 {
+		errs = append(errs, fmt.Errorf("retry period must be positive, got %s", c.RetryPeriod))
+	}
+	if c.MimirCheckInterval < 0 {
+		errs = append(errs, fmt.Errorf("mimir check interval must not be negative, got %s", c.MimirCheckInterval))
+	}
+	if c.WorkerStallTimeout < 0 {
+		errs = append(errs, fmt.Errorf("worker stall timeout must not be negative, got %s", c.WorkerStallTimeout))
+	}
+	if c.Shards < 1 {
+		errs = append(errs, fmt.Errorf("shards must be at least 1, got %d", c.Shards))
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("shutdown timeout must not be negative, got %s", c.ShutdownTimeout))
+	}
+	if c.RateLimiterBaseDelay <= 0 {
+		errs = append(errs, fmt.Errorf("rate limiter base delay must be positive, got %s", c.RateLimiterBaseDelay))
+	}
+	if c.RateLimiterMaxDelay < c.RateLimiterBaseDelay {
+		errs = append(errs, fmt.Errorf("rate limiter max delay (%s) must not be lower than base delay (%s)", c.RateLimiterMaxDelay, c.RateLimiterBaseDelay))
+	}
+	if c.RateLimiterQPS <= 0 {
+		errs = append(errs, fmt.Errorf("rate limiter qps must be positive, got %g", c.RateLimiterQPS))
+	}
+	if c.RateLimiterBurst < 1 {
+		errs = append(errs, fmt.Errorf("rate limiter burst must be at least 1, got %d", c.RateLimiterBurst))
+	}
+
+	if c.ControllerName == "" {
+		c.ControllerName = DefaultControllerName
+	}
+	switch c.UnselectedPolicy {
+	case "":
+		c.UnselectedPolicy = UnselectedPolicyDelete
+	case UnselectedPolicyDelete, UnselectedPolicyRetain:
+	default:
+		errs = append(errs, fmt.Errorf("unselected policy must be %q or %q, got %q", UnselectedPolicyDelete, UnselectedPolicyRetain, c.UnselectedPolicy))
+	}
+	if c.namespaceSelector, err = labels.Parse(c.NamespaceSelector); err != nil {
+		errs = append(errs, fmt.Errorf("invalid namespace selector: %w", err))
+	}
+	if c.ruleSelector, err = labels.Parse(c.RuleSelector); err != nil {
+		errs = append(errs, fmt.Errorf("invalid rule selector: %w", err))
+	}
+
+	tmpl, err := parseNamespaceTemplate(c.NamespaceTemplate)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	c.namespaceTemplate = tmpl
+
+	for i := range c.Policies {
+		if err := c.Policies[i].validate(); err != nil {
+			name := c.Policies[i].Name
+			if name == "" {
+				name = strconv.Itoa(i)
+			}
+			errs = append(errs, fmt.Errorf("policy %s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// withReloadable returns a copy of c with the settings of other that can
+// change while the controller runs.
+func (c *Config) withReloadable(other *Config) Config {
+	next := *c
+	next.NamespaceTemplate = other.NamespaceTemplate
+	next.DetectDuplicateAlerts = other.DetectDuplicateAlerts
+	next.UnselectedPolicy = other.UnselectedPolicy
+	next.AggregateNamespaces = other.AggregateNamespaces
+	next.MaxRulesPerRuleGroup = other.MaxRulesPerRuleGroup
+	next.MaxRuleGroupsPerTenant = other.MaxRuleGroupsPerTenant
+	next.Policies = other.Policies
+	return next
+}
+
+// RuleInformerOptions returns the options of the MimirRule informer factory,
+// restricting the informer to the watched namespace and the rule selector
+// where the API server can do it.
+func (c *Config) RuleInformerOptions() []rulesinformers.SharedInformerOption {
+	var options []rulesinformers.SharedInformerOption
+	if len(c.WatchNamespaces) == 1 {
+		options = append(options, rulesinformers.WithNamespace(c.WatchNamespaces[0]))
+	}
+	if c.RuleSelector != "" {
+		options = append(options, rulesinformers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = c.RuleSelector
+		}))
+	}
+	return options
+}
+
+// Identity returns the identity of the controller.
+func (c *Config) Identity() string {
+	if c.identity == "" {
+		c.identity = fmt.Sprintf("%s-%s", c.PodNamespace, c.PodName)
+	}
+	return c.identity
+}
+
+// RateLimiter returns the rate limiter of the work queue: the slowest of a
+// per-item exponential backoff and an overall token bucket, like
+// workqueue.DefaultControllerRateLimiter.
+func (c *Config) RateLimiter() workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(c.RateLimiterBaseDelay, c.RateLimiterMaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(c.RateLimiterQPS), c.RateLimiterBurst)},
+	)
+}
+
+// infoGauge returns a gauge exposing the effective runtime parameters as labels.
+func (c *Config) infoGauge() prometheus.Gauge {
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mimir_rules_controller_config_info",
+		Help: "Effective runtime configuration of the controller",
+		ConstLabels: prometheus.Labels{
+			"controller_name":         c.ControllerName,
+			"workers":                 strconv.Itoa(c.Workers),
+			"group_concurrency":       strconv.Itoa(c.GroupConcurrency),
+			"resync_period":           c.ResyncPeriod.String(),
+			"lease_duration":          c.LeaseDuration.String(),
+			"renew_deadline":          c.RenewDeadline.String(),
+			"retry_period":            c.RetryPeriod.String(),
+			"shards":                  strconv.Itoa(c.Shards),
+			"shutdown_timeout":        c.ShutdownTimeout.String(),
+			"rate_limiter_base_delay": c.RateLimiterBaseDelay.String(),
+			"rate_limiter_max_delay":  c.RateLimiterMaxDelay.String(),
+			"rate_limiter_qps":        strconv.FormatFloat(c.RateLimiterQPS, 'g', -1, 64),
+			"rate_limiter_burst":      strconv.Itoa(c.RateLimiterBurst),
+		},
+	})
+	gauge.Set(1)
+	return gauge
+}
@@ -0,0 +1,266 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+const (
+	// ReasonDuplicateGroupName is used when a rule group name is already taken
+	// by another MimirRule in the same Mimir namespace.
+	ReasonDuplicateGroupName = "DuplicateGroupName"
+	// ReasonDuplicateAlertName is used when an alert name is already used by
+	// another MimirRule in the same Kubernetes namespace.
+	ReasonDuplicateAlertName = "DuplicateAlertName"
+	// ReasonOwnershipConflict is used when the Mimir namespace of a MimirRule
+	// is owned by another controller or cluster.
+	ReasonOwnershipConflict = "OwnershipConflict"
+	// ReasonNoConflict is used when the MimirRule does not conflict with any
+	// other MimirRule.
+	ReasonNoConflict = "NoConflict"
+)
+
+// conflicts holds the conflicts of a MimirRule with other MimirRules.
+type conflicts struct {
+	// groups maps a conflicting group name to the key of the MimirRule that
+	// owns it.
+	groups map[string]string
+	// alerts maps a duplicated alert name to the keys of the other
+	// MimirRules using it.
+	alerts map[string][]string
+}
+
+// findConflicts checks rule against all the other MimirRules known to the
+// lister. A group name conflicts when another MimirRule renders into the same
+// Mimir namespace and defines a group with the same name; the group belongs
+// to the MimirRule that pushed it first, or to the oldest one if neither did.
+// Alert names are only compared when DetectDuplicateAlerts is enabled.
+func (c *Controller) findConflicts(rule *v1alpha1.MimirRule, mimirNamespace string) (*conflicts, error) {
+	found := &conflicts{groups: map[string]string{}, alerts: map[string][]string{}}
+
+	others, err := c.rulesLister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	config := c.config.Load()
+	groups := make(map[string]struct{}, len(rule.Spec.Groups))
+	alerts := map[string]struct{}{}
+	for _, group := range rule.Spec.Groups {
+		groups[config.GroupName(rule, group.Name)] = struct{}{}
+		for _, r := range group.Rules {
+			if r.Alert != "" {
+				alerts[r.Alert] = struct{}{}
+			}
+		}
+	}
+
+	for _, other := range others {
+		if other.UID == rule.UID || !other.DeletionTimestamp.IsZero() || !c.selects(other) {
+			continue
+		}
+		otherKey, err := cache.MetaNamespaceKeyFunc(other)
+		if err != nil {
+			return nil, err
+		}
+
+		otherNamespace, err := config.MimirNamespace(other)
+		if err != nil {
+			// The other rule reports its own error when it is synced
+			klog.ErrorS(err, "Error building the Mimir namespace", "mimirRule", klog.KObj(other))
+		} else if otherNamespace == mimirNamespace {
+			for _, group := range config.groupNames(other) {
+				if _, ok := groups[group]; ok && ownsGroup(other, rule, mimirNamespace, group) {
+					found.groups[group] = otherKey
+				}
+			}
+		}
+
+		if !config.detectDuplicateAlerts(rule.Namespace) || other.Namespace != rule.Namespace {
+			continue
+		}
+		for _, group := range other.Spec.Groups {
+			for _, r := range group.Rules {
+				if _, ok := alerts[r.Alert]; ok && r.Alert != "" {
+					found.alerts[r.Alert] = appendUnique(found.alerts[r.Alert], otherKey)
+				}
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// ownsGroup reports whether other takes precedence over rule for the given
+// group in the Mimir namespace.
+func ownsGroup(other, rule *v1alpha1.MimirRule, mimirNamespace, group string) bool {
+	otherPushed, rulePushed := hasPushed(other, mimirNamespace, group), hasPushed(rule, mimirNamespace, group)
+	if otherPushed != rulePushed {
+		return otherPushed
+	}
+	if !other.CreationTimestamp.Equal(&rule.CreationTimestamp) {
+		return other.CreationTimestamp.Before(&rule.CreationTimestamp)
+	}
+	if other.Namespace != rule.Namespace {
+		return other.Namespace < rule.Namespace
+	}
+	return other.Name < rule.Name
+}
+
+func hasPushed(rule *v1alpha1.MimirRule, mimirNamespace, group string) bool {
+	if rule.Status.MimirNamespace != mimirNamespace {
+		return false
+	}
+	for _, g := range rule.Status.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(keys []string, key string) []string {
+	for _, k := range keys {
+		if k == key {
+			return keys
+		}
+	}
+	return append(keys, key)
+}
+
+// reportConflicts records the conflicts in the status of rule and emits an
+// Event per conflict. It returns an error when rule must not be pushed.
+func (c *Controller) reportConflicts(ctx context.Context, rule *v1alpha1.MimirRule, found *conflicts) error {
+	var messages []string
+
+	for _, group := range sortedKeys(found.groups) {
+		msg := fmt.Sprintf("rule group '%s' is already defined by '%s'", group, found.groups[group])
+		c.eventf(ctx, rule, corev1.EventTypeWarning, ReasonDuplicateGroupName, "%s", msg)
+		messages = append(messages, msg)
+	}
+	for _, alert := range sortedKeys(found.alerts) {
+		msg := fmt.Sprintf("alert '%s' is also defined by '%s'", alert, strings.Join(found.alerts[alert], "', '"))
+		c.eventf(ctx, rule, corev1.EventTypeWarning, ReasonDuplicateAlertName, "%s", msg)
+		messages = append(messages, msg)
+	}
+
+	if len(messages) == 0 {
+		apimeta.SetStatusCondition(&rule.Status.Conditions, metav1.Condition{
+			Type:               string(v1alpha1.ConditionTypeConflict),
+			Status:             metav1.ConditionFalse,
+			LastTransitionTime: metav1.Now(),
+			Reason:             ReasonNoConflict,
+			Message:            "Rule does not conflict with other rules",
+			ObservedGeneration: rule.Generation,
+		})
+		return nil
+	}
+
+	reason := ReasonDuplicateAlertName
+	if len(found.groups) > 0 {
+		reason = ReasonDuplicateGroupName
+	}
+	apimeta.SetStatusCondition(&rule.Status.Conditions, metav1.Condition{
+		Type:               string(v1alpha1.ConditionTypeConflict),
+		Status:             metav1.ConditionTrue,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            strings.Join(messages, "; "),
+		ObservedGeneration: rule.Generation,
+	})
+
+	if len(found.groups) > 0 {
+		return fmt.Errorf("rule groups conflict with other rules: %s", strings.Join(messages[:len(found.groups)], "; "))
+	}
+	return nil
+}
+
+// conflictIndex indexes the MimirRules blocked by a conflict by the scopes
+// of the conflicts: the Mimir namespace they render to for the group names
+// and their Kubernetes namespace for the alert names. The Mimir namespace is
+// rendered when the rule is stored, a reload of the namespace template
+// resyncs the rules, which updates the index.
+const conflictIndex = "conflict"
+
+func mimirNamespaceIndexKey(namespace string) string {
+	return "mimir/" + namespace
+}
+
+func namespaceIndexKey(namespace string) string {
+	return "namespace/" + namespace
+}
+
+// conflictIndexFunc is the index function of conflictIndex.
+func (c *Controller) conflictIndexFunc(obj interface{}) ([]string, error) {
+	rule, ok := obj.(*v1alpha1.MimirRule)
+	if !ok || !apimeta.IsStatusConditionTrue(rule.Status.Conditions, string(v1alpha1.ConditionTypeConflict)) {
+		return nil, nil
+	}
+	keys := []string{namespaceIndexKey(rule.Namespace)}
+	if mimirNamespace, err := c.config.Load().MimirNamespace(rule); err == nil {
+		keys = append(keys, mimirNamespaceIndexKey(mimirNamespace))
+	}
+	return keys, nil
+}
+
+// enqueueConflicting enqueues the MimirRules blocked by a conflict in the
+// scopes of the changed rule, so they are retried when the rule they
+// conflict with changes or goes away. An updated rule is given in both its
+// old and new versions, as it may have left a Mimir namespace.
+func (c *Controller) enqueueConflicting(queue workqueue.RateLimitingInterface, objs ...interface{}) {
+	keys := map[string]struct{}{}
+	var changedUID types.UID
+	for _, obj := range objs {
+		changed, ok := ruleFromObject(obj)
+		if !ok {
+			continue
+		}
+		changedUID = changed.UID
+		keys[namespaceIndexKey(changed.Namespace)] = struct{}{}
+		if changed.Status.MimirNamespace != "" {
+			keys[mimirNamespaceIndexKey(changed.Status.MimirNamespace)] = struct{}{}
+		}
+		if mimirNamespace, err := c.config.Load().MimirNamespace(changed); err == nil {
+			keys[mimirNamespaceIndexKey(mimirNamespace)] = struct{}{}
+		}
+	}
+
+	enqueued := map[types.UID]struct{}{changedUID: {}}
+	for key := range keys {
+		found, err := c.ruleInformer.GetIndexer().ByIndex(conflictIndex, key)
+		if err != nil {
+			klog.ErrorS(err, "Error listing conflicting rules")
+			return
+		}
+		for _, obj := range found {
+			rule := obj.(*v1alpha1.MimirRule)
+			if _, ok := enqueued[rule.UID]; ok || !c.selects(rule) {
+				continue
+			}
+			enqueued[rule.UID] = struct{}{}
+			c.enqueueRule(queue, rule)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
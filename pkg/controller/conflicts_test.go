@@ -0,0 +1,67 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+// recordingQueue records the requests added to the work queue.
+type recordingQueue struct {
+	workqueue.RateLimitingInterface
+	added []string
+}
+
+func (q *recordingQueue) AddRateLimited(item interface{}) {
+	q.added = append(q.added, item.(reconcile.Request).String())
+}
+
+func TestEnqueueConflicting(t *testing.T) {
+	rule := func(namespace, name string, conflict bool) *v1alpha1.MimirRule {
+		r := newRule("a")
+		r.Namespace, r.Name, r.UID = namespace, name, types.UID(namespace+"/"+name)
+		if conflict {
+			r.Status.Conditions = []metav1.Condition{{Type: string(v1alpha1.ConditionTypeConflict), Status: metav1.ConditionTrue}}
+		}
+		return r
+	}
+	changed := rule("default", "rule", true)
+	moved := changed.DeepCopy()
+	moved.Status.MimirNamespace = "cluster:team"
+	rules := []*v1alpha1.MimirRule{
+		changed,
+		rule("default", "blocked", true),
+		rule("default", "clean", false),
+		rule("team", "blocked", true),
+		rule("other", "blocked", true),
+	}
+
+	tests := []struct {
+		name string
+		objs []interface{}
+		want []string
+	}{
+		{name: "deleted", objs: []interface{}{changed}, want: []string{"default/blocked"}},
+		{name: "moved", objs: []interface{}{moved, changed}, want: []string{"default/blocked", "team/blocked"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.NamespaceTemplate = "{{.Cluster}}:{{.Namespace}}"
+			tc := newTestController(t, config, rules...)
+			queue := &recordingQueue{}
+
+			tc.enqueueConflicting(queue, tt.objs...)
+			slices.Sort(queue.added)
+			if !slices.Equal(queue.added, tt.want) {
+				t.Errorf("enqueued %v, want %v", queue.added, tt.want)
+			}
+		})
+	}
+}
@@ -4,359 +4,701 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/grafana/mimir/pkg/mimirtool/client"
+	"github.com/grafana/mimir/pkg/mimirtool/rules"
 	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
 	kuberr "k8s.io/apimachinery/pkg/api/errors"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/kubernetes"
+	coreinformers "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
-	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
 
 	clientset "github.com/healthjoy/mimir-rules-controller/pkg/generated/clientset/versioned"
+	rulesscheme "github.com/healthjoy/mimir-rules-controller/pkg/generated/clientset/versioned/scheme"
 	informers "github.com/healthjoy/mimir-rules-controller/pkg/generated/informers/externalversions/rulescontroller/v1alpha1"
 	listers "github.com/healthjoy/mimir-rules-controller/pkg/generated/listers/rulescontroller/v1alpha1"
+	"github.com/healthjoy/mimir-rules-controller/pkg/mimir"
+	"github.com/healthjoy/mimir-rules-controller/pkg/ruletest"
+	"github.com/healthjoy/mimir-rules-controller/pkg/tracing"
+	"github.com/healthjoy/mimir-rules-controller/pkg/validation"
 )
 
 const controllerAgentName = "mimir-rules-controller"
 
-// Config is the configuration for the controller.
-type Config struct {
-	// ClusterName is the name of the cluster and use as the first part of the
-	// Namespace of RuleNamespace in Mimir
-	ClusterName string
-	// PodName is the name of the pod running the controller.
-	PodName string
-	// PodNamespace is the namespace of the pod running the controller.
-	PodNamespace string
-	// LeaseLockName is the name of the lease lock.
-	LeaseLockName string
-	// LeaseLockNamespace is the namespace of the lease lock.
-	LeaseLockNamespace string
-
-	identity string
-}
-
-// Identity returns the identity of the controller.
-func (c *Config) Identity() string {
-	if c.identity == "" {
-		c.identity = fmt.Sprintf("%s-%s", c.PodNamespace, c.PodName)
-	}
-	return c.identity
-}
-
 // Controller is the controller implementation for Rule resources
 type Controller struct {
-	config *Config
+	// config is swapped by UpdateConfig while the controller runs.
+	config atomic.Pointer[Config]
 	// rulesclientset is a clientset for our own API group
 	rulesclientset clientset.Interface
 
 	// Mimir client
-	mimirclient *client.MimirClient
+	mimirclient *mimir.Client
+	// limits are the ruler limits fetched from Mimir, if any
+	limits atomic.Pointer[mimir.UserLimits]
+	// mimirStatus is the result of the last connectivity check of Mimir
+	mimirStatus atomic.Pointer[mimirStatus]
 
 	// rulesLister can list/get rules from the shared informer's store
 	rulesLister listers.MimirRuleLister
-	// rulesSynced returns true if the rules shared informer has been synced at least once
-	rulesSynced cache.InformerSynced
-
-	// workqueue is a rate limited work queue. This is used to queue work to be
-	// processed instead of performing it as soon as a change happens. This
-	// means we can ensure we only process a fixed amount of resources at a
-	// time, and makes it easy to ensure we are never processing the same item
-	// simultaneously in two different workers.
-	workqueue workqueue.RateLimitingInterface
+	// ruleInformer is the shared informer feeding the controller
+	ruleInformer cache.SharedIndexInformer
+	// namespaceLister can get namespaces to match the namespace selector,
+	// nil if no namespace selector is set
+	namespaceLister corelisters.NamespaceLister
+	// namespaceInformer is the shared informer of namespaces, nil if no
+	// namespace selector is set
+	namespaceInformer cache.SharedIndexInformer
+	// shards are the shards of rule keys owned by this replica
+	shards *shards
+	// members counts the replicas sharing the shards, nil without sharding
+	members *members
+	// queue is the work queue of the running controller
+	queue   workqueue.RateLimitingInterface
+	queueMu sync.Mutex
+	// progress is the time in unix nanoseconds a worker last finished a rule
+	progress atomic.Int64
+	// unselected holds the keys of the rules that left the selectors and
+	// still have to be released
+	unselected sync.Map
+
 	// recorder is an event recorder for recording Event resources to the
 	// Kubernetes API.
 	recorder record.EventRecorder
 
-	// syncCounter prometheus counter
-	syncCounter prometheus.Counter
+	// syncCounter prometheus counter of the syncs per namespace and result
+	syncCounter *prometheus.CounterVec
+
+	// syncErrorCounter prometheus counter of the sync errors per namespace
+	// and class, permanent errors are not retried
+	syncErrorCounter *prometheus.CounterVec
+
+	// syncHistogram prometheus histogram of the sync duration per namespace
+	// and result
+	syncHistogram *prometheus.HistogramVec
+
+	// tenantGroupsGauge prometheus gauge of the rule groups per tenant
+	tenantGroupsGauge *prometheus.GaugeVec
+
+	// rulerLimitGauge prometheus gauge of the ruler limits fetched from Mimir
+	rulerLimitGauge *prometheus.GaugeVec
 
-	// syncErrorCounter prometheus counter
-	syncErrorCounter prometheus.Counter
+	// shardsGauge prometheus gauge of the shards owned by the replica
+	shardsGauge prometheus.Gauge
 
-	// syncHistogram prometheus histogram
-	syncHistogram prometheus.Histogram
+	// mimirUpGauge prometheus gauge of the last connectivity check of Mimir
+	mimirUpGauge prometheus.Gauge
+
+	// mimirLastSuccessGauge prometheus gauge of the time Mimir was last reachable
+	mimirLastSuccessGauge prometheus.Gauge
 }
 
 // NewController returns a new rules controller.
 func NewController(
 	config Config,
-	kubeclientset kubernetes.Interface,
 	rulesclientset clientset.Interface,
-	mimirclient *client.MimirClient,
+	mimirclient *mimir.Client,
 	ruleinformer informers.MimirRuleInformer,
+	namespaceinformer coreinformers.NamespaceInformer,
 	reg *prometheus.Registry) *Controller {
-	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartStructuredLogging(0)
-	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
-	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+	// Add rules types to the default Kubernetes Scheme so Events can be
+	// logged for MimirRule resources.
+	utilruntime.Must(rulesscheme.AddToScheme(scheme.Scheme))
 
 	controller := &Controller{
-		config:         &config,
 		rulesclientset: rulesclientset,
 		mimirclient:    mimirclient,
 		rulesLister:    ruleinformer.Lister(),
-		rulesSynced:    ruleinformer.Informer().HasSynced,
-		workqueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Rules"),
-		recorder:       recorder,
+		ruleInformer:   ruleinformer.Informer(),
+		shards:         newShards(config.Shards),
 
-		syncCounter: prometheus.NewCounter(prometheus.CounterOpts{
+		syncCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "mimir_rules_controller_sync_total",
-			Help: "Total number of syncs",
-		}),
+			Help: "Total number of syncs by namespace and result",
+		}, []string{"namespace", "result"}),
 
-		syncErrorCounter: prometheus.NewCounter(prometheus.CounterOpts{
+		syncErrorCounter: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "mimir_rules_controller_sync_errors_total",
-			Help: "Total number of sync errors",
-		}),
+			Help: "Total number of sync errors by namespace and class, permanent errors are not retried until the rule changes",
+		}, []string{"namespace", "class"}),
 
-		syncHistogram: prometheus.NewHistogram(prometheus.HistogramOpts{
+		syncHistogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
 			Name: "mimir_rules_controller_sync_duration_seconds",
-			Help: "Sync duration in seconds",
+			Help: "Sync duration in seconds by namespace and result",
+		}, []string{"namespace", "result"}),
+
+		tenantGroupsGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mimir_rules_controller_tenant_rule_groups",
+			Help: "Number of rule groups managed by the controller per tenant",
+		}, []string{"tenant"}),
+
+		rulerLimitGauge: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mimir_rules_controller_ruler_limit",
+			Help: "Ruler limits of the tenant fetched from Mimir",
+		}, []string{"tenant", "limit"}),
+
+		shardsGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mimir_rules_controller_owned_shards",
+			Help: "Number of shards of rules owned by the replica",
+		}),
+
+		mimirUpGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mimir_rules_controller_mimir_up",
+			Help: "Whether the last connectivity check of the Mimir ruler API succeeded",
+		}),
+
+		mimirLastSuccessGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mimir_rules_controller_mimir_last_success_timestamp_seconds",
+			Help: "Time of the last successful connectivity check of the Mimir ruler API",
 		}),
 	}
 
+	controller.config.Store(&config)
+	utilruntime.Must(controller.ruleInformer.AddIndexers(cache.Indexers{conflictIndex: controller.conflictIndexFunc}))
+
 	reg.MustRegister(controller.syncCounter)
 	reg.MustRegister(controller.syncErrorCounter)
 	reg.MustRegister(controller.syncHistogram)
-
-	klog.Info("Setting up event handlers")
-	_, _ = ruleinformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    controller.enqueueRule,
-		DeleteFunc: controller.enqueueRule,
-		UpdateFunc: func(old, new interface{}) {
-			controller.enqueueRule(new)
-		},
-	})
+	reg.MustRegister(controller.tenantGroupsGauge)
+	reg.MustRegister(controller.rulerLimitGauge)
+	reg.MustRegister(controller.shardsGauge)
+	reg.MustRegister(controller.mimirUpGauge)
+	reg.MustRegister(&ruleCollector{controller: controller})
+	reg.MustRegister(controller.mimirLastSuccessGauge)
+	reg.MustRegister(config.infoGauge())
+
+	if namespaceinformer != nil {
+		controller.namespaceLister = namespaceinformer.Lister()
+		controller.namespaceInformer = namespaceinformer.Informer()
+	}
 
 	return controller
 }
 
-// Run will set up the event handlers for types we are interested in, as well
-// as syncing informer caches and starting workers. It will block until ctx is
-// cancelled, at which point it will shutdown the workqueue and wait for
-// workers to finish processing their current work items.
-func (c *Controller) Run(ctx context.Context, threadiness int) error {
-	defer runtime.HandleCrash()
-	defer c.workqueue.ShutDown()
+// SetupWithManager registers the controller with the Manager. The controller
+// runs on every replica, fed by the shared informer which must be started by
+// the caller, and reconciles the rules of the shards whose Lease the replica
+// holds.
+func (c *Controller) SetupWithManager(mgr manager.Manager, leases coordinationclient.LeasesGetter) error {
+	c.recorder = mgr.GetEventRecorderFor(controllerAgentName)
+
+	ctrl, err := crcontroller.New(controllerAgentName, mgr, crcontroller.Options{
+		Reconciler:              c,
+		MaxConcurrentReconciles: c.config.Load().Workers,
+		RateLimiter:             c.config.Load().RateLimiter(),
+		LogConstructor: func(req *reconcile.Request) klog.Logger {
+			logger := mgr.GetLogger().WithValues("controller", controllerAgentName)
+			if req != nil {
+				logger = logger.WithValues("mimirRule", klog.KRef(req.Namespace, req.Name))
+			}
+			return logger
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating controller: %w", err)
+	}
+	klog.InfoS("Setting up event handlers")
+	if err := ctrl.Watch(&ruleSource{controller: c}); err != nil {
+		return fmt.Errorf("error watching rules: %w", err)
+	}
+
+	if c.config.Load().RulerLimitsRefreshInterval > 0 {
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			klog.InfoS("Starting ruler limits refresh", "interval", c.config.Load().RulerLimitsRefreshInterval)
+			wait.UntilWithContext(ctx, c.refreshLimits, c.config.Load().RulerLimitsRefreshInterval)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
 
-	// runServer the informer factories to begin populating the informer caches
-	klog.Info("Starting Rules controller")
+	if c.config.Load().MimirCheckInterval > 0 {
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			klog.InfoS("Starting Mimir connectivity check", "interval", c.config.Load().MimirCheckInterval)
+			wait.UntilWithContext(ctx, func(ctx context.Context) { _ = c.CheckMimir(ctx) }, c.config.Load().MimirCheckInterval)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
 
-	// Wait for the caches to be synced before starting workers
-	klog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(ctx.Done(), c.rulesSynced); !ok {
-		return fmt.Errorf("failed to wait for caches to sync")
+	if c.config.Load().Shards > 1 {
+		c.members = &members{config: c.config.Load(), leases: leases}
+		if err := mgr.Add(c.members); err != nil {
+			return err
+		}
+	}
+	for shard := 0; shard < c.config.Load().Shards; shard++ {
+		if err := mgr.Add(&elector{controller: c, lock: c.leaseLock(leases, shard), shard: shard}); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	klog.Info("Starting workers")
-	// Launch two workers to process Rules resources
-	for i := 0; i < threadiness; i++ {
-		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+// leaseLock returns the Lease lock of a shard. Without sharding the only
+// shard uses the configured lease lock name.
+func (c *Controller) leaseLock(leases coordinationclient.LeasesGetter, shard int) resourcelock.Interface {
+	name := c.config.Load().LeaseLockName
+	if c.config.Load().Shards > 1 {
+		name = fmt.Sprintf("%s-shard-%d", c.config.Load().LeaseLockName, shard)
+	}
+	return &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: c.config.Load().LeaseLockNamespace,
+		},
+		Client: leases,
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: c.config.Load().Identity(),
+		},
 	}
+}
 
-	klog.Info("Started workers")
+// shardQuota returns the number of shards this replica should own.
+func (c *Controller) shardQuota() int {
+	if c.members == nil {
+		return c.shards.quota(1)
+	}
+	return c.shards.quota(c.members.len())
+}
 
-	<-ctx.Done()
-	klog.Info("Shutting down workers")
+// enqueueShard enqueues the rules of a shard this replica just acquired.
+// Before the controller has started the initial informer events do it.
+func (c *Controller) enqueueShard(shard int) {
+	c.queueMu.Lock()
+	queue := c.queue
+	c.queueMu.Unlock()
+	if queue == nil {
+		return
+	}
+	rules, err := c.rulesLister.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Error listing the rules of a shard", "shard", shard)
+		return
+	}
+	for _, rule := range rules {
+		if shardOf(rule.Namespace+"/"+rule.Name, c.config.Load().Shards) == shard && c.selects(rule) {
+			c.enqueueRule(queue, rule)
+		}
+	}
+}
 
+// UpdateConfig applies the settings of config that can change while the
+// controller runs, i.e. the namespace template, duplicate alert detection,
+// unselected policy, ruler limits and policies, and resyncs the managed rules
+// with them. The other settings are ignored.
+func (c *Controller) UpdateConfig(config Config) error {
+	next := c.config.Load().withReloadable(&config)
+	if err := next.Validate(); err != nil {
+		return err
+	}
+	c.config.Store(&next)
+	c.enqueueAll()
 	return nil
 }
 
-func (c *Controller) runWorker(ctx context.Context) {
-	for c.processNextItem(ctx) {
+// enqueueAll enqueues the rules of the shards this replica owns.
+func (c *Controller) enqueueAll() {
+	c.queueMu.Lock()
+	queue := c.queue
+	c.queueMu.Unlock()
+	if queue == nil {
+		return
+	}
+	rules, err := c.rulesLister.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Error listing the rules to resync")
+		return
+	}
+	for _, rule := range rules {
+		if c.shards.owns(rule.Namespace+"/"+rule.Name) && c.selects(rule) {
+			c.enqueueRule(queue, rule)
+		}
 	}
 }
 
-func (c *Controller) processNextItem(ctx context.Context) bool {
-	obj, shutdown := c.workqueue.Get()
+// Reconcile syncs the MimirRule named in the request with Mimir. Returning
+// an error requeues the request with the configured rate limiter.
+func (c *Controller) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	defer c.progress.Store(time.Now().UnixNano())
 
-	if shutdown {
-		return false
+	key := req.NamespacedName.String()
+	shard, term, ok := c.shards.begin(key)
+	if !ok {
+		// The shard was handed over since the rule was enqueued
+		return reconcile.Result{}, nil
 	}
+	defer c.shards.end(shard)
 
-	err := func(obj interface{}) error {
-		defer c.workqueue.Done(obj)
-		var key string
-		var ok bool
-		if key, ok = obj.(string); !ok {
-			c.workqueue.Forget(obj)
-			runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
-			return nil
-		}
-		if err := c.syncHandler(ctx, key); err != nil {
-			return fmt.Errorf("error syncing '%s': %s", key, err.Error())
-		}
-		c.workqueue.Forget(obj)
-		klog.Infof("Successfully synced '%s'", key)
-		return nil
-	}(obj)
+	// Hold the rules back while Mimir is down instead of burning retries,
+	// without growing their backoff.
+	if !c.mimirUp() {
+		return reconcile.Result{RequeueAfter: c.config.Load().MimirCheckInterval}, nil
+	}
 
-	if err != nil {
-		utilruntime.HandleError(err)
-		return true
+	// Let the Mimir calls and the status update in flight finish when the
+	// controller stops or the shard is handed over rather than leaving Mimir
+	// partially updated.
+	ctx, cancel := withGracePeriod(ctx, term, c.config.Load().ShutdownTimeout)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "Reconcile", tracing.RuleKey.String(key), tracing.Tenant.String(c.mimirclient.TenantID()))
+	// The logger of controller-runtime names the rule and the reconcile
+	logger := klog.FromContext(ctx)
+	traceID := tracing.TraceID(ctx)
+	if traceID != "" {
+		logger = logger.WithValues("traceID", traceID)
+		ctx = klog.NewContext(ctx, logger)
 	}
 
-	return true
+	err := c.syncHandler(ctx, key)
+	tracing.End(span, err)
+	if err != nil {
+		// Mimir is throttling or the circuit breaker is open, retry when
+		// requests get through again rather than with the per-rule backoff
+		var retryErr *mimir.RetryAfterError
+		if errors.As(err, &retryErr) {
+			logger.Info("Requeuing rule", "delay", retryErr.Delay, "reason", retryErr.Reason)
+			return reconcile.Result{RequeueAfter: retryErr.Delay}, nil
+		}
+		// The failure is recorded in the status, retrying would fail the
+		// same way until the rule changes
+		if isPermanent(err) {
+			logger.Info("Not retrying rule until it changes", "err", err.Error())
+			return reconcile.Result{}, nil
+		}
+		if traceID != "" {
+			return reconcile.Result{}, fmt.Errorf("error syncing '%s' (trace %s): %s", key, traceID, err.Error())
+		}
+		return reconcile.Result{}, fmt.Errorf("error syncing '%s': %s", key, err.Error())
+	}
+	logger.Info("Successfully synced rule")
+	return reconcile.Result{}, nil
 }
 
-func (c *Controller) syncHandler(ctx context.Context, key string) error {
+func (c *Controller) syncHandler(ctx context.Context, key string) (err error) {
 	startTime := time.Now()
+	ctx, syncSpan := tracing.Start(ctx, "syncHandler", tracing.RuleKey.String(key))
+	defer func() { tracing.End(syncSpan, err) }()
+	logger := klog.FromContext(ctx)
 
 	// Convert the namespace/name string into a distinct namespace and name
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
-		runtime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		logger.Error(err, "Invalid resource key")
 		return nil
 	}
 
 	// Get the Rule resource with this namespace/name
+	_, span := tracing.Start(ctx, "GetMimirRule")
 	rule, err := c.rulesLister.MimirRules(namespace).Get(name)
+	tracing.End(span, err)
 	if err != nil {
 		// The Rule resource may no longer exist, in which case we stop
 		// processing.
 		if kuberr.IsNotFound(err) {
-			runtime.HandleError(fmt.Errorf("rule '%s' in work queue no longer exists", key))
+			if _, ok := c.unselected.Load(key); ok {
+				return c.syncUnselected(ctx, key, namespace, name)
+			}
+			logger.Info("Rule in work queue no longer exists")
 			return nil
 		}
 
 		return err
 	}
+	if !c.selects(rule) {
+		return c.syncUnselected(ctx, key, namespace, name)
+	}
+	c.unselected.Delete(key)
+	// Never mutate objects from the shared informer cache, other rules read
+	// their status when checking for conflicts.
+	rule = rule.DeepCopy()
 
-	klog.Info("Check rule generation")
+	config := c.config.Load()
+	mimirNamespace, err := config.MimirNamespace(rule)
+	if err != nil {
+		logger.Error(err, "Error building the Mimir namespace")
+		c.eventf(ctx, rule, corev1.EventTypeWarning, ReasonInvalidNamespace, "%s", err.Error())
+		return permanent(err)
+	}
+	syncSpan.SetAttributes(tracing.MimirNamespace.String(mimirNamespace))
+	logger = logger.WithValues("mimirNamespace", mimirNamespace)
+	ctx = klog.NewContext(ctx, logger)
+
+	logger.V(4).Info("Checking rule generation")
 	if condition := apimeta.FindStatusCondition(rule.Status.Conditions, string(v1alpha1.ConditionTypeReady)); condition != nil {
-		if rule.Generation-condition.ObservedGeneration <= 1 {
-			klog.Info("Rule is up to date")
+		if rule.Generation-condition.ObservedGeneration <= 1 && rule.Status.MimirNamespace == mimirNamespace &&
+			slices.Equal(rule.Status.Groups, config.groupNames(rule)) {
+			logger.V(2).Info("Rule is up to date")
 			return nil
 		}
 	}
 
 	// Setup defer to update sync metrics
 	defer func() {
-		c.syncCounter.Inc()
-		c.syncHistogram.Observe(time.Since(startTime).Seconds())
+		result := "success"
 		if err != nil {
-			c.syncErrorCounter.Inc()
+			result = "error"
+			class := "transient"
+			if isPermanent(err) {
+				class = "permanent"
+			}
+			c.syncErrorCounter.WithLabelValues(namespace, class).Inc()
 		}
+		c.syncCounter.WithLabelValues(namespace, result).Inc()
+		c.syncHistogram.WithLabelValues(namespace, result).Observe(time.Since(startTime).Seconds())
 	}()
 
 	if !rule.ObjectMeta.DeletionTimestamp.IsZero() {
 		if controllerutil.ContainsFinalizer(rule, v1alpha1.RuleFinalizer) {
-			for _, group := range rule.Spec.Groups {
-				if err := c.mimirclient.DeleteRuleGroup(ctx, fmt.Sprintf("%s:%s:%s", c.config.ClusterName, namespace, name), group.Name); err != nil {
-					runtime.HandleError(fmt.Errorf("error deleting rule group '%s' for rule '%s': %s", group.Name, key, err.Error()))
+			// Rules synced before the status recorded the pushed groups
+			// fall back to the groups of the spec.
+			if rule.Status.MimirNamespace == "" {
+				if err := c.deleteGroups(ctx, rule, mimirNamespace, config.groupNames(rule)); err != nil {
+					err = fmt.Errorf("error deleting rule groups for rule '%s': %w", key, err)
+					c.eventf(ctx, rule, corev1.EventTypeWarning, ReasonCleanupFailed, "%s", err.Error())
+					logger.Error(err, "Error deleting rule groups")
 					return err
 				}
 			}
+			if err := c.deleteStaleGroups(ctx, rule, mimirNamespace, nil); err != nil {
+				c.eventf(ctx, rule, corev1.EventTypeWarning, ReasonCleanupFailed, "%s", err.Error())
+				logger.Error(err, "Error deleting rule groups")
+				return err
+			}
 			controllerutil.RemoveFinalizer(rule, v1alpha1.RuleFinalizer)
 			if _, err := c.rulesclientset.RulescontrollerV1alpha1().MimirRules(rule.Namespace).Update(ctx, rule, metav1.UpdateOptions{}); err != nil {
-				runtime.HandleError(fmt.Errorf("error removing finalizer from rule '%s': %s", key, err.Error()))
+				logger.Error(err, "Error removing finalizer")
 				return err
 			}
+			c.eventf(ctx, rule, corev1.EventTypeNormal, ReasonCleanedUp, "Deleted the rule groups from Mimir and removed the finalizer")
 		}
-		klog.Infof("Rule '%s' deleted", key)
+		logger.Info("Rule deleted")
 		return nil
 	}
 
-	klog.Info("Setup deferred function")
 	defer func() {
+		ctx, span := tracing.Start(ctx, "UpdateStatus")
+		defer span.End()
 		if _, dErr := c.rulesclientset.RulescontrollerV1alpha1().MimirRules(rule.Namespace).Update(ctx, rule, metav1.UpdateOptions{}); dErr != nil {
 			dErr = fmt.Errorf("error updating rule status: %w", dErr)
-			runtime.HandleError(dErr)
+			span.RecordError(dErr)
+			logger.Error(dErr, "Error updating rule status")
 			if err != nil {
-				err = fmt.Errorf("exit with %w; update error %w", err, dErr)
+				// The status is not recorded, so even a permanent error has
+				// to be retried
+				err = fmt.Errorf("exit with %s; update error %w", err.Error(), dErr)
 			} else {
 				err = dErr
 			}
 		}
-		klog.Info("Updated rule status")
+		logger.V(4).Info("Updated rule status")
 	}()
 
 	if !controllerutil.ContainsFinalizer(rule, v1alpha1.RuleFinalizer) {
 		controllerutil.AddFinalizer(rule, v1alpha1.RuleFinalizer)
+		c.eventf(ctx, rule, corev1.EventTypeNormal, ReasonFinalizerAdded, "Added the finalizer, the rule groups are pushed to Mimir next")
 		return nil
 	}
 
 	// Do something with the rule here
-	klog.Info("Processing rule")
-	mimirRuleNs, err := rule.Spec.GetMimirRuleNamespace(fmt.Sprintf("%s:%s:%s", c.config.ClusterName, rule.Namespace, rule.Name))
+	logger.V(4).Info("Processing rule")
+	_, span = tracing.Start(ctx, "GetMimirRuleNamespace")
+	mimirRuleNs, err := rule.Spec.GetMimirRuleNamespace(mimirNamespace)
+	tracing.End(span, err)
 	if err != nil {
 		err := fmt.Errorf("error getting mimir rule namespace: %w", err)
-		statusCondition := metav1.Condition{
-			Type:               string(v1alpha1.ConditionTypeFailed),
-			Status:             metav1.ConditionFalse,
-			LastTransitionTime: metav1.Now(),
-			Reason:             "Error",
-			Message:            err.Error(),
-			ObservedGeneration: rule.Generation,
+		c.setFailed(ctx, rule, ReasonInvalidSpec, err)
+		logger.Error(err, "Invalid rule spec")
+		return permanent(err)
+	}
+	for i := range mimirRuleNs.Groups {
+		mimirRuleNs.Groups[i].Name = config.GroupName(rule, mimirRuleNs.Groups[i].Name)
+		if mimirRuleNs.Groups[i].Name == OwnerGroup {
+			err := fmt.Errorf("rule group name '%s' is reserved", OwnerGroup)
+			c.setFailed(ctx, rule, ReasonInvalidSpec, err)
+			logger.Error(err, "Invalid rule spec")
+			return permanent(err)
 		}
-		apimeta.SetStatusCondition(&rule.Status.Conditions, statusCondition)
-		runtime.HandleError(err)
-		return err
 	}
-	if errs := mimirRuleNs.Validate(); len(errs) > 0 {
+	_, span = tracing.Start(ctx, "Validate")
+	errs := mimirRuleNs.Validate()
+	tracing.End(span, errors.Join(errs...))
+	if len(errs) > 0 {
 		err := fmt.Errorf("validation err: %w", errors.Join(errs...))
-		runtime.HandleError(fmt.Errorf("rule '%s' in work queue has invalid rules: %w", key, err))
-		statusCondition := metav1.Condition{
-			Type:               string(v1alpha1.ConditionTypeFailed),
-			Status:             metav1.ConditionFalse,
+		logger.Error(err, "Rule has invalid rules")
+		c.setFailed(ctx, rule, ReasonValidationFailed, err)
+		return permanent(err)
+	}
+	_, span = tracing.Start(ctx, "ValidateTemplates")
+	errs = validation.Templates(mimirRuleNs)
+	tracing.End(span, errors.Join(errs...))
+	if len(errs) > 0 {
+		err := fmt.Errorf("rule '%s' in work queue has invalid templates: %w", key, errors.Join(errs...))
+		c.setFailed(ctx, rule, ReasonInvalidTemplates, err)
+		logger.Error(err, "Rule has invalid templates")
+		return permanent(err)
+	}
+	_, span = tracing.Start(ctx, "LintExpressions")
+	_, _, err = mimirRuleNs.LintExpressions("mimir")
+	tracing.End(span, err)
+	if err != nil {
+		err := fmt.Errorf("rule '%s' in work queue has invalid expressions: %s", key, err)
+		c.setFailed(ctx, rule, ReasonLintFailed, err)
+		logger.Error(err, "Rule has invalid expressions")
+		return permanent(err)
+	}
+	if len(rule.Spec.Tests) > 0 {
+		testCtx, span := tracing.Start(ctx, "RunTests")
+		errs := ruletest.Run(testCtx, mimirRuleNs, rule.Spec.Tests, ruletest.DefaultLimits)
+		tracing.End(span, errors.Join(errs...))
+		// Stopping the controller is no failure of the tests
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(errs) > 0 {
+			err := fmt.Errorf("rule '%s' in work queue failed unit tests: %w", key, errors.Join(errs...))
+			apimeta.SetStatusCondition(&rule.Status.Conditions, metav1.Condition{
+				Type:               string(v1alpha1.ConditionTypeTested),
+				Status:             metav1.ConditionFalse,
+				LastTransitionTime: metav1.Now(),
+				Reason:             ReasonTestsFailed,
+				Message:            errors.Join(errs...).Error(),
+				ObservedGeneration: rule.Generation,
+			})
+			c.setFailed(ctx, rule, ReasonTestsFailed, err)
+			logger.Error(err, "Rule failed unit tests")
+			return permanent(err)
+		}
+		apimeta.SetStatusCondition(&rule.Status.Conditions, metav1.Condition{
+			Type:               string(v1alpha1.ConditionTypeTested),
+			Status:             metav1.ConditionTrue,
 			LastTransitionTime: metav1.Now(),
-			Reason:             "Error",
-			Message:            err.Error(),
+			Reason:             "TestsPassed",
+			Message:            fmt.Sprintf("%d unit tests passed", len(rule.Spec.Tests)),
 			ObservedGeneration: rule.Generation,
-		}
-		apimeta.SetStatusCondition(&rule.Status.Conditions, statusCondition)
-		runtime.HandleError(err)
+		})
+	} else {
+		apimeta.RemoveStatusCondition(&rule.Status.Conditions, string(v1alpha1.ConditionTypeTested))
+	}
+
+	_, span = tracing.Start(ctx, "FindConflicts")
+	found, err := c.findConflicts(rule, mimirRuleNs.Namespace)
+	tracing.End(span, err)
+	if err != nil {
+		logger.Error(err, "Error checking rule for conflicts")
 		return err
 	}
-	if _, _, err = mimirRuleNs.LintExpressions("mimir"); err != nil {
-		err := fmt.Errorf("rule '%s' in work queue has invalid expressions: %s", key, err)
-		statusCondition := metav1.Condition{
+	if err = c.reportConflicts(ctx, rule, found); err != nil {
+		// reportConflicts records an Event per conflict
+		err := fmt.Errorf("rule '%s' in work queue has conflicts: %w", key, err)
+		apimeta.SetStatusCondition(&rule.Status.Conditions, metav1.Condition{
 			Type:               string(v1alpha1.ConditionTypeFailed),
 			Status:             metav1.ConditionFalse,
 			LastTransitionTime: metav1.Now(),
-			Reason:             "Error",
+			Reason:             ReasonConflict,
 			Message:            err.Error(),
 			ObservedGeneration: rule.Generation,
-		}
-		apimeta.SetStatusCondition(&rule.Status.Conditions, statusCondition)
-		runtime.HandleError(err)
-		return err
+		})
+		logger.Error(err, "Rule has conflicts")
+		return permanent(err)
 	}
-	klog.Info("Creating rule")
-	for _, group := range mimirRuleNs.Groups {
-		err := c.mimirclient.CreateRuleGroup(ctx, mimirRuleNs.Namespace, group)
-		if err != nil {
-			err := fmt.Errorf("error creating rule group: %w", err)
-			statusCondition := metav1.Condition{
-				Type:               string(v1alpha1.ConditionTypeFailed),
-				Status:             metav1.ConditionFalse,
+	_, span = tracing.Start(ctx, "CheckLimits")
+	err = c.checkLimits(rule, mimirRuleNs)
+	tracing.End(span, err)
+	if err != nil {
+		err := fmt.Errorf("rule '%s' in work queue exceeds ruler limits: %w", key, err)
+		c.setFailed(ctx, rule, ReasonLimitExceeded, err)
+		logger.Error(err, "Rule exceeds ruler limits")
+		return permanent(err)
+	}
+	logger.V(4).Info("Claiming Mimir namespace")
+	if err = c.claimNamespace(ctx, mimirRuleNs.Namespace); err != nil {
+		if isNotOwner(err) {
+			apimeta.SetStatusCondition(&rule.Status.Conditions, metav1.Condition{
+				Type:               string(v1alpha1.ConditionTypeConflict),
+				Status:             metav1.ConditionTrue,
 				LastTransitionTime: metav1.Now(),
-				Reason:             "Error",
+				Reason:             ReasonOwnershipConflict,
 				Message:            err.Error(),
 				ObservedGeneration: rule.Generation,
-			}
-			apimeta.SetStatusCondition(&rule.Status.Conditions, statusCondition)
-			runtime.HandleError(err)
-			return err
+			})
+			err := fmt.Errorf("rule '%s' in work queue cannot be pushed: %w", key, err)
+			c.setFailed(ctx, rule, ReasonConflict, err)
+			logger.Error(err, "Mimir namespace is owned by another controller")
+			return permanent(err)
 		}
+		c.setFailed(ctx, rule, ReasonMimirError, err)
+		logger.Error(err, "Error claiming the Mimir namespace")
+		return err
+	}
+	logger.V(4).Info("Creating rule groups", "concurrency", config.GroupConcurrency)
+	results := c.createGroups(ctx, mimirRuleNs, config.GroupConcurrency)
+	var failed, pushed []string
+	var groupErrs []error
+	for i, group := range mimirRuleNs.Groups {
+		if results[i] != nil {
+			err := fmt.Errorf("error creating rule group '%s': %w", group.Name, results[i])
+			logger.Error(err, "Error creating rule group", "group", group.Name)
+			failed = append(failed, group.Name)
+			groupErrs = append(groupErrs, err)
+			continue
+		}
+		logger.V(2).Info("Pushed rule group", "group", group.Name)
+		pushed = append(pushed, group.Name)
+		if rule.Status.MimirNamespace == mimirRuleNs.Namespace && contains(rule.Status.Groups, group.Name) {
+			c.eventf(ctx, rule, corev1.EventTypeNormal, ReasonGroupUpdated, "Updated rule group '%s' in Mimir namespace '%s'", group.Name, mimirRuleNs.Namespace)
+		} else {
+			c.eventf(ctx, rule, corev1.EventTypeNormal, ReasonGroupCreated, "Created rule group '%s' in Mimir namespace '%s'", group.Name, mimirRuleNs.Namespace)
+		}
+	}
+	if len(failed) > 0 {
+		err := fmt.Errorf("error creating %d of %d rule groups: %w", len(failed), len(mimirRuleNs.Groups), &groupErrors{errs: groupErrs})
+		if cleanupErr := c.trackPushedGroups(ctx, rule, mimirRuleNs.Namespace, pushed); cleanupErr != nil {
+			logger.Error(cleanupErr, "Error removing the rule groups pushed to the new Mimir namespace")
+			err = errors.Join(err, cleanupErr)
+		}
+		c.setFailed(ctx, rule, ReasonMimirError, err)
+		return err
 	}
 
-	klog.Info("Rule created, updating status")
+	groups := make([]string, 0, len(mimirRuleNs.Groups))
+	for _, group := range mimirRuleNs.Groups {
+		groups = append(groups, group.Name)
+	}
+	if err = c.deleteStaleGroups(ctx, rule, mimirRuleNs.Namespace, groups); err != nil {
+		c.setFailed(ctx, rule, ReasonMimirError, err)
+		logger.Error(err, "Error deleting stale rule groups")
+		return err
+	}
+	rule.Status.MimirNamespace = mimirRuleNs.Namespace
+	rule.Status.Groups = groups
+
+	logger.V(4).Info("Rule groups pushed, updating status")
 	statusCondition := metav1.Condition{
 		Type:               string(v1alpha1.ConditionTypeReady),
 		Status:             metav1.ConditionTrue,
@@ -366,16 +708,65 @@ func (c *Controller) syncHandler(ctx context.Context, key string) error {
 		ObservedGeneration: rule.Generation,
 	}
 	apimeta.SetStatusCondition(&rule.Status.Conditions, statusCondition)
+	apimeta.RemoveStatusCondition(&rule.Status.Conditions, string(v1alpha1.ConditionTypeFailed))
+	c.eventf(ctx, rule, corev1.EventTypeNormal, ReasonSynced, "Pushed %d rule groups to Mimir namespace '%s'", len(groups), mimirRuleNs.Namespace)
 
-	klog.Info("Done processing rule")
+	logger.V(4).Info("Done processing rule")
 	return nil
 }
 
-func (c *Controller) enqueueRule(obj interface{}) {
-	key, err := cache.MetaNamespaceKeyFunc(obj)
-	if err != nil {
-		runtime.HandleError(err)
+// trackPushedGroups keeps track of the groups pushed to namespace by a sync
+// that failed for other groups, so they are cleaned up even if the rule goes
+// away before a retry succeeds. The status holds a single Mimir namespace:
+// groups pushed to the namespace of the status, or to the first namespace of
+// the rule, are recorded in it, while groups pushed to a namespace replacing
+// the one of the status are deleted again, and the retry pushes them anew.
+func (c *Controller) trackPushedGroups(ctx context.Context, rule *v1alpha1.MimirRule, namespace string, pushed []string) error {
+	if rule.Status.MimirNamespace == "" || rule.Status.MimirNamespace == namespace {
+		if len(pushed) > 0 {
+			rule.Status.MimirNamespace = namespace
+		}
+		for _, group := range pushed {
+			if !contains(rule.Status.Groups, group) {
+				rule.Status.Groups = append(rule.Status.Groups, group)
+			}
+		}
+		return nil
+	}
+	if err := c.deleteGroups(ctx, rule, namespace, pushed); err != nil {
+		return err
+	}
+	return c.releaseNamespace(ctx, rule, namespace)
+}
+
+// createGroups pushes the rule groups of ns to Mimir, at most concurrency at
+// a time, and returns the error of each group. A failed group does not stop
+// the others.
+func (c *Controller) createGroups(ctx context.Context, ns *rules.RuleNamespace, concurrency int) []error {
+	errs := make([]error, len(ns.Groups))
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i, group := range ns.Groups {
+		g.Go(func() error {
+			groupCtx, span := tracing.Start(ctx, "CreateRuleGroup", tracing.MimirNamespace.String(ns.Namespace), tracing.RuleGroup.String(group.Name))
+			errs[i] = c.mimirclient.CreateRuleGroup(groupCtx, ns.Namespace, group)
+			tracing.End(span, errs[i])
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return errs
+}
+
+func (c *Controller) enqueueRule(queue workqueue.RateLimitingInterface, obj interface{}) {
+	rule, ok := ruleFromObject(obj)
+	if !ok {
+		return
+	}
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: rule.Namespace, Name: rule.Name}}
+	// Rules of shards owned by other replicas are left to them
+	if !c.shards.owns(request.String()) {
 		return
 	}
-	c.workqueue.AddRateLimited(key)
+	queue.AddRateLimited(request)
 }
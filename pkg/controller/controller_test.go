@@ -0,0 +1,305 @@
+package controller
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirtool/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/yaml.v3"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+	"github.com/healthjoy/mimir-rules-controller/pkg/generated/clientset/versioned/fake"
+	rulesinformers "github.com/healthjoy/mimir-rules-controller/pkg/generated/informers/externalversions"
+	listers "github.com/healthjoy/mimir-rules-controller/pkg/generated/listers/rulescontroller/v1alpha1"
+	"github.com/healthjoy/mimir-rules-controller/pkg/mimir"
+)
+
+// fakeMimir is a Mimir ruler API answering the push of each rule group with
+// the status code set for the group, 202 Accepted by default.
+type fakeMimir struct {
+	mu    sync.Mutex
+	codes map[string]int
+	// requests are the method and path of the requests received
+	requests []string
+}
+
+func (m *fakeMimir) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, r.Method+" "+r.URL.Path)
+	code := http.StatusAccepted
+	if r.Method == http.MethodPost {
+		var group struct {
+			Name string `yaml:"name"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		_ = yaml.Unmarshal(body, &group)
+		if c, ok := m.codes[group.Name]; ok {
+			code = c
+		}
+	}
+	if code == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "3")
+	}
+	w.WriteHeader(code)
+}
+
+// testConfig returns a valid configuration managing the MimirRules without
+// a controller name.
+func testConfig() Config {
+	return Config{
+		ClusterName:          "cluster",
+		DefaultController:    true,
+		Workers:              DefaultWorkers,
+		GroupConcurrency:     DefaultGroupConcurrency,
+		LeaseDuration:        DefaultLeaseDuration,
+		RenewDeadline:        DefaultRenewDeadline,
+		RetryPeriod:          DefaultRetryPeriod,
+		Shards:               DefaultShards,
+		ShutdownTimeout:      DefaultShutdownTimeout,
+		RateLimiterBaseDelay: DefaultRateLimiterBaseDelay,
+		RateLimiterMaxDelay:  DefaultRateLimiterMaxDelay,
+		RateLimiterQPS:       DefaultRateLimiterQPS,
+		RateLimiterBurst:     DefaultRateLimiterBurst,
+	}
+}
+
+// newRule returns a MimirRule default/rule taken over by the controller,
+// with a recording rule group per name.
+func newRule(groups ...string) *v1alpha1.MimirRule {
+	rule := &v1alpha1.MimirRule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "rule",
+			Namespace:  "default",
+			UID:        "uid",
+			Generation: 1,
+			Finalizers: []string{v1alpha1.RuleFinalizer},
+		},
+	}
+	for _, group := range groups {
+		rule.Spec.Groups = append(rule.Spec.Groups, v1alpha1.RuleGroup{
+			Name:  group,
+			Rules: []v1alpha1.Rule{{Record: "job:up:sum", Expr: intstr.FromString("sum by (job) (up)")}},
+		})
+	}
+	return rule
+}
+
+// testController is a Controller reconciling the MimirRules of its lister
+// against a fake Mimir, with all the shards.
+type testController struct {
+	*Controller
+	mimir    *fakeMimir
+	recorder *record.FakeRecorder
+	// updated is the last MimirRule written to the API server, if any
+	updated *v1alpha1.MimirRule
+}
+
+func newTestController(t *testing.T, config Config, rules ...*v1alpha1.MimirRule) *testController {
+	t.Helper()
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tc := &testController{mimir: &fakeMimir{codes: map[string]int{}}, recorder: record.NewFakeRecorder(100)}
+	server := httptest.NewServer(tc.mimir)
+	t.Cleanup(server.Close)
+	mimirClient, err := mimir.New(client.Config{Address: server.URL, ID: "tenant"}, mimir.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rulesClient := fake.NewSimpleClientset()
+	rulesClient.PrependReactor("update", "mimirrules", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		rule := action.(k8stesting.UpdateAction).GetObject().(*v1alpha1.MimirRule)
+		tc.updated = rule.DeepCopy()
+		return true, rule, nil
+	})
+	informer := rulesinformers.NewSharedInformerFactory(rulesClient, 0).Rulescontroller().V1alpha1().MimirRules()
+	for _, rule := range rules {
+		if err := informer.Informer().GetIndexer().Add(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tc.Controller = NewController(config, rulesClient, mimirClient, informer, nil, prometheus.NewRegistry())
+	tc.Controller.recorder = tc.recorder
+	for shard := 0; shard < config.Shards; shard++ {
+		tc.shards.acquire(context.Background(), shard)
+	}
+	return tc
+}
+
+// reconcile reconciles default/rule.
+func (tc *testController) reconcile() (reconcile.Result, error) {
+	return tc.Reconcile(context.Background(), reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "rule"}})
+}
+
+// events returns the type and reason of the Events recorded so far.
+func (tc *testController) events() []string {
+	var events []string
+	for {
+		select {
+		case event := <-tc.recorder.Events:
+			fields := strings.Fields(event)
+			events = append(events, fields[0]+" "+fields[1])
+		default:
+			return events
+		}
+	}
+}
+
+// TestReconcileErrors checks that rejections by Mimir are not retried, that
+// throttling is retried after the delay asked by Mimir and that the other
+// failures are retried with backoff.
+func TestReconcileErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes map[string]int
+		// wantRequeueAfter is set when the rule is retried after a delay
+		wantRequeueAfter time.Duration
+		// wantErr is set when the rule is retried with backoff
+		wantErr bool
+		// wantClass is the class of the sync error counted, if any
+		wantClass string
+	}{
+		{name: "success"},
+		{name: "bad request", codes: map[string]int{"a": http.StatusBadRequest}, wantClass: "permanent"},
+		{name: "too large", codes: map[string]int{"a": http.StatusRequestEntityTooLarge}, wantClass: "permanent"},
+		{name: "unprocessable", codes: map[string]int{"a": http.StatusUnprocessableEntity}, wantClass: "permanent"},
+		{name: "throttled", codes: map[string]int{"a": http.StatusTooManyRequests}, wantRequeueAfter: 3 * time.Second, wantClass: "transient"},
+		{name: "internal error", codes: map[string]int{"a": http.StatusInternalServerError}, wantErr: true, wantClass: "transient"},
+		{name: "unavailable", codes: map[string]int{"a": http.StatusServiceUnavailable}, wantErr: true, wantClass: "transient"},
+		{
+			name:      "all groups rejected",
+			codes:     map[string]int{"a": http.StatusBadRequest, "b": http.StatusUnprocessableEntity},
+			wantClass: "permanent",
+		},
+		{
+			name:      "rejected and unavailable",
+			codes:     map[string]int{"a": http.StatusBadRequest, "b": http.StatusServiceUnavailable},
+			wantErr:   true,
+			wantClass: "transient",
+		},
+		{
+			name:             "rejected and throttled",
+			codes:            map[string]int{"a": http.StatusBadRequest, "b": http.StatusTooManyRequests},
+			wantRequeueAfter: 3 * time.Second,
+			wantClass:        "transient",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestController(t, testConfig(), newRule("a", "b"))
+			tc.mimir.codes = tt.codes
+
+			result, err := tc.reconcile()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.RequeueAfter != tt.wantRequeueAfter {
+				t.Errorf("Reconcile() RequeueAfter = %s, want %s", result.RequeueAfter, tt.wantRequeueAfter)
+			}
+			if tc.updated == nil {
+				t.Fatal("status not updated")
+			}
+			failed := apimeta.FindStatusCondition(tc.updated.Status.Conditions, string(v1alpha1.ConditionTypeFailed))
+			if (failed != nil) != (tt.wantClass != "") {
+				t.Errorf("Failed condition = %v, want one %v", failed, tt.wantClass != "")
+			}
+			for _, class := range []string{"permanent", "transient"} {
+				want := 0.0
+				if class == tt.wantClass {
+					want = 1
+				}
+				if got := testutil.ToFloat64(tc.syncErrorCounter.WithLabelValues("default", class)); got != want {
+					t.Errorf("%s sync errors = %g, want %g", class, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestTrackPushedGroups checks that the groups pushed by a partially failed
+// sync are either recorded in the status or deleted from Mimir.
+func TestTrackPushedGroups(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      v1alpha1.RuleStatus
+		wantStatus  v1alpha1.RuleStatus
+		wantDeleted []string
+	}{
+		{
+			name:       "first namespace",
+			wantStatus: v1alpha1.RuleStatus{MimirNamespace: "new", Groups: []string{"a", "b"}},
+		},
+		{
+			name:       "same namespace",
+			status:     v1alpha1.RuleStatus{MimirNamespace: "new", Groups: []string{"b", "c"}},
+			wantStatus: v1alpha1.RuleStatus{MimirNamespace: "new", Groups: []string{"b", "c", "a"}},
+		},
+		{
+			name:        "namespace changed",
+			status:      v1alpha1.RuleStatus{MimirNamespace: "old", Groups: []string{"a", "b", "c"}},
+			wantStatus:  v1alpha1.RuleStatus{MimirNamespace: "old", Groups: []string{"a", "b", "c"}},
+			wantDeleted: []string{"/prometheus/config/v1/rules/new/a", "/prometheus/config/v1/rules/new/b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var deleted []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodDelete {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				mu.Lock()
+				deleted = append(deleted, r.URL.Path)
+				mu.Unlock()
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer server.Close()
+			mimirClient, err := mimir.New(client.Config{Address: server.URL, ID: "tenant"}, mimir.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := &Controller{
+				mimirclient: mimirClient,
+				rulesLister: listers.NewMimirRuleLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+				recorder:    record.NewFakeRecorder(10),
+			}
+			c.config.Store(&Config{})
+			rule := testRule("a")
+			rule.Status = tt.status
+
+			if err := c.trackPushedGroups(context.Background(), rule, "new", []string{"a", "b"}); err != nil {
+				t.Fatalf("trackPushedGroups() error = %v", err)
+			}
+			if rule.Status.MimirNamespace != tt.wantStatus.MimirNamespace || !slices.Equal(rule.Status.Groups, tt.wantStatus.Groups) {
+				t.Errorf("status = %v %v, want %v %v", rule.Status.MimirNamespace, rule.Status.Groups, tt.wantStatus.MimirNamespace, tt.wantStatus.Groups)
+			}
+			slices.Sort(deleted)
+			if !slices.Equal(deleted, tt.wantDeleted) {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// elector campaigns for the Lease of a shard and makes this replica own the
+// shard while holding it. Losing the lease puts the replica back into the
+// candidate state instead of exiting the process, and the lease is only
+// released, on shutdown or to rebalance the shards, once the in-flight rules
+// of the shard are done.
+type elector struct {
+	controller *Controller
+	lock       resourcelock.Interface
+	shard      int
+
+	// term is held while the replica owns the shard, so a new term never
+	// starts before the previous one has drained.
+	term sync.Mutex
+}
+
+var _ manager.LeaderElectionRunnable = &elector{}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The elector
+// campaigns for the lease itself, so it runs on every replica.
+func (e *elector) NeedLeaderElection() bool {
+	return false
+}
+
+// Start campaigns for the lease until ctx is cancelled, as long as this
+// replica owns less than its quota of shards.
+func (e *elector) Start(ctx context.Context) error {
+	config := e.controller.config.Load()
+	for ctx.Err() == nil {
+		if e.controller.shards.len() >= e.controller.shardQuota() {
+			select {
+			case <-ctx.Done():
+			case <-time.After(config.RetryPeriod):
+			}
+			continue
+		}
+		if err := e.campaign(ctx); err != nil {
+			return err
+		}
+	}
+	// Wait for the last term to drain
+	e.term.Lock()
+	defer e.term.Unlock()
+	klog.InfoS("Stopped campaigning for lease", "lease", e.lock.Describe())
+	return nil
+}
+
+// campaign waits for the lease and owns the shard while holding it. It
+// returns once the lease is lost or released.
+func (e *elector) campaign(ctx context.Context) error {
+	config := e.controller.config.Load()
+
+	// The elector gets a context of its own so that, on shutdown, the lease
+	// is released only after the shard has drained.
+	electCtx, stopElecting := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopElecting()
+	var leading atomic.Bool
+	defer context.AfterFunc(ctx, func() {
+		if !leading.Load() {
+			stopElecting()
+		}
+	})()
+
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Name:            e.lock.Describe(),
+		Lock:            e.lock,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaseCtx context.Context) {
+				e.term.Lock()
+				defer e.term.Unlock()
+				leading.Store(true)
+				defer stopElecting()
+				if ctx.Err() != nil {
+					return
+				}
+
+				termCtx, stopTerm := context.WithCancel(leaseCtx)
+				defer stopTerm()
+				defer context.AfterFunc(ctx, stopTerm)()
+
+				klog.InfoS("Started leading", "lease", e.lock.Describe())
+				e.lead(termCtx, stopTerm)
+			},
+			OnStoppedLeading: func() {
+				klog.InfoS("Stopped leading", "lease", e.lock.Describe())
+			},
+			OnNewLeader: func(identity string) {
+				if identity == config.Identity() {
+					return
+				}
+				klog.InfoS("New leader elected", "lease", e.lock.Describe(), "leader", identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating leader elector: %w", err)
+	}
+	le.Run(electCtx)
+	return nil
+}
+
+// lead owns the shard until ctx is cancelled or the shard is handed over to
+// rebalance the shards, then waits for its in-flight rules.
+func (e *elector) lead(ctx context.Context, stop context.CancelFunc) {
+	c := e.controller
+	c.shards.acquire(ctx, e.shard)
+	c.shardsGauge.Set(float64(c.shards.len()))
+	c.enqueueShard(e.shard)
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if c.shards.surplus(e.shard, c.shardQuota()) {
+			klog.InfoS("Handing over the lease to rebalance the shards", "lease", e.lock.Describe())
+			stop()
+		}
+	}, c.config.Load().RetryPeriod)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), c.config.Load().ShutdownTimeout+c.config.Load().RetryPeriod)
+	defer cancel()
+	c.shards.release(drainCtx, e.shard)
+	c.shardsGauge.Set(float64(c.shards.len()))
+}
+
+// withGracePeriod returns a context carrying the values of ctx that is
+// cancelled grace after ctx or term is cancelled, so the work started under
+// them can finish.
+func withGracePeriod(ctx, term context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	graceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	var once sync.Once
+	startGrace := func() {
+		once.Do(func() {
+			timer := time.AfterFunc(grace, cancel)
+			context.AfterFunc(graceCtx, func() { timer.Stop() })
+		})
+	}
+	stopCtx := context.AfterFunc(ctx, startGrace)
+	stopTerm := context.AfterFunc(term, startGrace)
+	return graceCtx, func() {
+		stopCtx()
+		stopTerm()
+		cancel()
+	}
+}
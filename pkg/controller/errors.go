@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"errors"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/mimir"
+)
+
+// permanentError is a sync error that retrying cannot fix until the MimirRule
+// changes, such as an invalid spec.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent marks err as permanent.
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// isPermanent reports whether err is permanent, either marked by the
+// controller or a rejection by Mimir. Any other error is transient.
+func isPermanent(err error) bool {
+	var permanentErr *permanentError
+	return errors.As(err, &permanentErr) || mimir.IsPermanent(err)
+}
+
+// groupErrors are the errors of the rule groups that could not be pushed. The
+// sync only fails permanently when all of them are permanent, so the
+// permanent ones are hidden while a transient one remains.
+type groupErrors struct {
+	errs []error
+}
+
+func (e *groupErrors) Error() string {
+	return errors.Join(e.errs...).Error()
+}
+
+func (e *groupErrors) Unwrap() []error {
+	var transient []error
+	for _, err := range e.errs {
+		if !isPermanent(err) {
+			transient = append(transient, err)
+		}
+	}
+	if len(transient) == 0 {
+		return e.errs
+	}
+	return transient
+}
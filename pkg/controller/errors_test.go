@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/mimir"
+)
+
+func TestIsPermanent(t *testing.T) {
+	rejected := func(code int) error {
+		return fmt.Errorf("create group: %w", &mimir.StatusError{StatusCode: code, Status: http.StatusText(code)})
+	}
+	throttled := fmt.Errorf("create group: %w", &mimir.RetryAfterError{Delay: 1, Reason: "throttled"})
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "marked", err: permanent(errors.New("invalid spec")), want: true},
+		{name: "wrapped mark", err: fmt.Errorf("sync: %w", permanent(errors.New("invalid spec"))), want: true},
+		{name: "bad request", err: rejected(http.StatusBadRequest), want: true},
+		{name: "too large", err: rejected(http.StatusRequestEntityTooLarge), want: true},
+		{name: "unprocessable", err: rejected(http.StatusUnprocessableEntity), want: true},
+		{name: "throttled", err: throttled},
+		{name: "other", err: errors.New("connection refused")},
+		{name: "all groups permanent", err: &groupErrors{errs: []error{rejected(http.StatusBadRequest), permanent(errors.New("invalid"))}}, want: true},
+		{name: "a group transient", err: &groupErrors{errs: []error{rejected(http.StatusBadRequest), errors.New("connection refused")}}},
+		{name: "a group throttled", err: &groupErrors{errs: []error{rejected(http.StatusUnprocessableEntity), throttled}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPermanent(tt.err); got != tt.want {
+				t.Errorf("isPermanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGroupErrorsRetryAfter checks that the delay asked by Mimir for a group
+// is found next to the rejection of another group.
+func TestGroupErrorsRetryAfter(t *testing.T) {
+	err := error(&groupErrors{errs: []error{
+		&mimir.StatusError{StatusCode: http.StatusBadRequest},
+		&mimir.RetryAfterError{Delay: 5, Reason: "throttled"},
+	}})
+	var retryErr *mimir.RetryAfterError
+	if !errors.As(err, &retryErr) || retryErr.Delay != 5 {
+		t.Errorf("errors.As(%v) = %v, want the RetryAfterError", err, retryErr)
+	}
+	if want := "Mimir rejected the request: \nthrottled, retry after 5ns"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+	"github.com/healthjoy/mimir-rules-controller/pkg/tracing"
+)
+
+// Reasons of the Events recorded on MimirRules and of their Failed condition.
+// They are part of the API, alerting and tooling match on them.
+const (
+	// ReasonFinalizerAdded is used when the controller takes over a MimirRule.
+	ReasonFinalizerAdded = "FinalizerAdded"
+	// ReasonGroupCreated is used when a rule group is pushed to Mimir for the
+	// first time.
+	ReasonGroupCreated = "GroupCreated"
+	// ReasonGroupUpdated is used when a rule group already pushed to Mimir is
+	// pushed again.
+	ReasonGroupUpdated = "GroupUpdated"
+	// ReasonGroupDeleted is used when a rule group is deleted from Mimir.
+	ReasonGroupDeleted = "GroupDeleted"
+	// ReasonSynced is used when all the rule groups of a MimirRule are pushed.
+	ReasonSynced = "Synced"
+	// ReasonInvalidNamespace is used when the Mimir namespace of a MimirRule
+	// cannot be rendered.
+	ReasonInvalidNamespace = "InvalidNamespace"
+	// ReasonInvalidSpec is used when the spec cannot be turned into rule
+	// groups.
+	ReasonInvalidSpec = "InvalidSpec"
+	// ReasonValidationFailed is used when the rule groups are invalid.
+	ReasonValidationFailed = "ValidationFailed"
+	// ReasonInvalidTemplates is used when the templates of the rules are
+	// invalid.
+	ReasonInvalidTemplates = "InvalidTemplates"
+	// ReasonLintFailed is used when the expressions of the rules are invalid.
+	ReasonLintFailed = "LintFailed"
+	// ReasonTestsFailed is used when the unit tests of a MimirRule fail.
+	ReasonTestsFailed = "TestsFailed"
+	// ReasonConflict is used when a MimirRule conflicts with other MimirRules.
+	// Each conflict is recorded with its own reason by reportConflicts.
+	ReasonConflict = "Conflict"
+	// ReasonLimitExceeded is used when pushing the rule groups would exceed
+	// the ruler limits of the tenant.
+	ReasonLimitExceeded = "LimitExceeded"
+	// ReasonMimirError is used when a request to the Mimir ruler API fails.
+	ReasonMimirError = "MimirError"
+	// ReasonCleanedUp is used when the rule groups of a deleted MimirRule are
+	// removed from Mimir.
+	ReasonCleanedUp = "CleanedUp"
+	// ReasonCleanupFailed is used when the rule groups of a deleted MimirRule
+	// cannot be removed from Mimir.
+	ReasonCleanupFailed = "CleanupFailed"
+	// ReasonReleased is used when a MimirRule that left the selectors is
+	// handed back.
+	ReasonReleased = "Released"
+)
+
+// TraceIDAnnotation is the annotation of the Events holding the ID of the
+// trace of the sync that recorded them.
+const TraceIDAnnotation = "rulescontroller.k8s.healthjoy.com/trace-id"
+
+// eventf records an Event on rule, annotated with the trace ID of ctx.
+func (c *Controller) eventf(ctx context.Context, rule *v1alpha1.MimirRule, eventtype, reason, messageFmt string, args ...interface{}) {
+	traceID := tracing.TraceID(ctx)
+	if traceID == "" {
+		c.recorder.Eventf(rule, eventtype, reason, messageFmt, args...)
+		return
+	}
+	c.recorder.AnnotatedEventf(rule, map[string]string{TraceIDAnnotation: traceID}, eventtype, reason, messageFmt, args...)
+}
+
+// setFailed records err on rule as the Failed condition and as a Warning
+// Event with the given reason.
+func (c *Controller) setFailed(ctx context.Context, rule *v1alpha1.MimirRule, reason string, err error) {
+	apimeta.SetStatusCondition(&rule.Status.Conditions, metav1.Condition{
+		Type:               string(v1alpha1.ConditionTypeFailed),
+		Status:             metav1.ConditionFalse,
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            err.Error(),
+		ObservedGeneration: rule.Generation,
+	})
+	c.eventf(ctx, rule, corev1.EventTypeWarning, reason, "%s", err.Error())
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+// readyRule returns newRule marked as synced to namespace with its groups,
+// at a generation newer than the sync.
+func readyRule(namespace string, groups ...string) *v1alpha1.MimirRule {
+	rule := newRule(groups...)
+	rule.Generation = 3
+	rule.Status.MimirNamespace = namespace
+	rule.Status.Groups = groups
+	rule.Status.Conditions = []metav1.Condition{{
+		Type:               string(v1alpha1.ConditionTypeReady),
+		Status:             metav1.ConditionTrue,
+		Reason:             ReasonSynced,
+		ObservedGeneration: 1,
+	}}
+	return rule
+}
+
+func TestSyncEvents(t *testing.T) {
+	config := testConfig()
+	namespace, err := config.MimirNamespace(newRule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		rule  *v1alpha1.MimirRule
+		codes map[string]int
+		want  []string
+	}{
+		{
+			name: "finalizer added",
+			rule: func() *v1alpha1.MimirRule {
+				rule := newRule("a")
+				rule.Finalizers = nil
+				return rule
+			}(),
+			want: []string{"Normal " + ReasonFinalizerAdded},
+		},
+		{
+			name: "created",
+			rule: newRule("a", "b"),
+			want: []string{"Normal " + ReasonGroupCreated, "Normal " + ReasonGroupCreated, "Normal " + ReasonSynced},
+		},
+		{
+			name: "updated",
+			rule: readyRule(namespace, "a"),
+			want: []string{"Normal " + ReasonGroupUpdated, "Normal " + ReasonSynced},
+		},
+		{
+			name: "group removed",
+			rule: func() *v1alpha1.MimirRule {
+				rule := readyRule(namespace, "a", "b")
+				rule.Spec.Groups = rule.Spec.Groups[:1]
+				return rule
+			}(),
+			want: []string{"Normal " + ReasonGroupUpdated, "Normal " + ReasonGroupDeleted, "Normal " + ReasonSynced},
+		},
+		{
+			name: "deleted",
+			rule: func() *v1alpha1.MimirRule {
+				rule := readyRule(namespace, "a")
+				rule.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+				return rule
+			}(),
+			want: []string{"Normal " + ReasonGroupDeleted, "Normal " + ReasonCleanedUp},
+		},
+		{
+			name: "invalid spec",
+			rule: func() *v1alpha1.MimirRule {
+				rule := newRule("a")
+				rule.Spec.Groups[0].Interval = "often"
+				return rule
+			}(),
+			want: []string{"Warning " + ReasonInvalidSpec},
+		},
+		{
+			name: "validation failed",
+			rule: func() *v1alpha1.MimirRule {
+				rule := newRule("a")
+				rule.Spec.Groups[0].Rules[0].Record = "not a metric"
+				return rule
+			}(),
+			want: []string{"Warning " + ReasonValidationFailed},
+		},
+		{
+			name: "invalid templates",
+			rule: func() *v1alpha1.MimirRule {
+				rule := newRule("a")
+				rule.Spec.Groups[0].Rules[0] = v1alpha1.Rule{
+					Alert:       "Down",
+					Expr:        rule.Spec.Groups[0].Rules[0].Expr,
+					Annotations: map[string]string{"summary": `{{ template "missing" . }}`},
+				}
+				return rule
+			}(),
+			want: []string{"Warning " + ReasonInvalidTemplates},
+		},
+		{
+			name:  "mimir error",
+			rule:  newRule("a"),
+			codes: map[string]int{"a": http.StatusBadRequest},
+			want:  []string{"Warning " + ReasonMimirError},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestController(t, testConfig(), tt.rule)
+			tc.mimir.codes = tt.codes
+			if _, err := tc.reconcile(); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if got := tc.events(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestEventTraceID checks that the Events of a traced sync carry its trace ID.
+func TestEventTraceID(t *testing.T) {
+	traceID := trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	traced := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8},
+		TraceFlags: trace.FlagsSampled,
+	}))
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{name: "untraced", ctx: context.Background(), want: "Normal Synced done"},
+		{name: "traced", ctx: traced, want: "Normal Synced done map[" + TraceIDAnnotation + ":" + traceID.String() + "]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(1)
+			recorder.IncludeObject = false
+			c := &Controller{recorder: recorder}
+			c.eventf(tt.ctx, newRule(), "Normal", ReasonSynced, "%s", "done")
+			if got := strings.TrimSpace(<-recorder.Events); got != tt.want {
+				t.Errorf("event = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// InformersSynced is a readiness check passing once the informer caches
+// have synced.
+func (c *Controller) InformersSynced(*http.Request) error {
+	if !c.ruleInformer.HasSynced() {
+		return errors.New("rule informer has not synced")
+	}
+	if c.namespaceInformer != nil && !c.namespaceInformer.HasSynced() {
+		return errors.New("namespace informer has not synced")
+	}
+	return nil
+}
+
+// Workers is a liveness check failing when rules are waiting in the work
+// queue but no worker finished a rule for WorkerStallTimeout.
+func (c *Controller) Workers(*http.Request) error {
+	c.queueMu.Lock()
+	queue := c.queue
+	c.queueMu.Unlock()
+	if queue == nil {
+		return nil
+	}
+
+	pending := queue.Len()
+	idle := time.Since(time.Unix(0, c.progress.Load())).Truncate(time.Second)
+	if c.config.Load().WorkerStallTimeout > 0 && pending > 0 && idle > c.config.Load().WorkerStallTimeout {
+		return fmt.Errorf("%d rules pending, no progress for %s", pending, idle)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/grafana/mimir/pkg/mimirtool/rules"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+	"github.com/healthjoy/mimir-rules-controller/pkg/mimir"
+)
+
+// rulerLimits returns the ruler limits currently in effect.
+func (c *Controller) rulerLimits() mimir.UserLimits {
+	if limits := c.limits.Load(); limits != nil {
+		return *limits
+	}
+	return mimir.UserLimits{
+		RulerMaxRulesPerRuleGroup:   c.config.Load().MaxRulesPerRuleGroup,
+		RulerMaxRuleGroupsPerTenant: c.config.Load().MaxRuleGroupsPerTenant,
+	}
+}
+
+// refreshLimits fetches the ruler limits of the tenant from Mimir. The
+// configured limits stay in effect until the first successful fetch.
+func (c *Controller) refreshLimits(ctx context.Context) {
+	limits, err := c.mimirclient.UserLimits(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Error fetching ruler limits")
+		return
+	}
+	if previous := c.limits.Swap(limits); previous == nil || *previous != *limits {
+		klog.InfoS("Using ruler limits from Mimir", "tenant", c.mimirclient.TenantID(),
+			"rulesPerRuleGroup", limits.RulerMaxRulesPerRuleGroup, "ruleGroupsPerTenant", limits.RulerMaxRuleGroupsPerTenant)
+	}
+	c.rulerLimitGauge.WithLabelValues(c.mimirclient.TenantID(), "ruler_max_rules_per_rule_group").Set(float64(limits.RulerMaxRulesPerRuleGroup))
+	c.rulerLimitGauge.WithLabelValues(c.mimirclient.TenantID(), "ruler_max_rule_groups_per_tenant").Set(float64(limits.RulerMaxRuleGroupsPerTenant))
+}
+
+// checkLimits returns an error when pushing the rule groups of ns on behalf
+// of rule would exceed the ruler limits of the tenant. The tenant group count
+// is made of the groups pushed by every other MimirRule plus the groups of
+// rule, so the error names the resource that would cross the limit.
+func (c *Controller) checkLimits(rule *v1alpha1.MimirRule, ns *rules.RuleNamespace) error {
+	limits := c.rulerLimits()
+	var errs []error
+
+	if limits.RulerMaxRulesPerRuleGroup > 0 {
+		for _, group := range ns.Groups {
+			if len(group.Rules) > limits.RulerMaxRulesPerRuleGroup {
+				errs = append(errs, fmt.Errorf("rule group '%s' has %d rules, exceeding the limit of %d rules per rule group",
+					group.Name, len(group.Rules), limits.RulerMaxRulesPerRuleGroup))
+			}
+		}
+	}
+
+	others, err := c.rulesLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	groups := map[string]struct{}{}
+	namespaces := map[string]struct{}{ns.Namespace: {}}
+	for _, other := range others {
+		if other.UID == rule.UID || !c.selects(other) {
+			continue
+		}
+		for _, group := range other.Status.Groups {
+			groups[other.Status.MimirNamespace+"/"+group] = struct{}{}
+		}
+		if len(other.Status.Groups) > 0 {
+			namespaces[other.Status.MimirNamespace] = struct{}{}
+		}
+	}
+	for _, group := range ns.Groups {
+		groups[ns.Namespace+"/"+group.Name] = struct{}{}
+	}
+	// Each Mimir namespace also holds an owner group
+	if c.config.Load().OwnershipMarkers {
+		for namespace := range namespaces {
+			groups[namespace+"/"+OwnerGroup] = struct{}{}
+		}
+	}
+	c.tenantGroupsGauge.WithLabelValues(c.mimirclient.TenantID()).Set(float64(len(groups)))
+
+	if limits.RulerMaxRuleGroupsPerTenant > 0 && len(groups) > limits.RulerMaxRuleGroupsPerTenant {
+		errs = append(errs, fmt.Errorf("pushing %d rule groups would bring tenant '%s' to %d rule groups, exceeding the limit of %d rule groups per tenant",
+			len(ns.Groups), c.mimirclient.TenantID(), len(groups), limits.RulerMaxRuleGroupsPerTenant))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/grafana/mimir/pkg/mimirtool/rules"
+	"github.com/grafana/mimir/pkg/mimirtool/rules/rwrulefmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+func TestCheckLimits(t *testing.T) {
+	// pushed returns a MimirRule whose groups were pushed to namespace
+	pushed := func(name, namespace string, groups ...string) *v1alpha1.MimirRule {
+		rule := newRule(groups...)
+		rule.Name = name
+		rule.UID = types.UID(name)
+		rule.Status.MimirNamespace = namespace
+		rule.Status.Groups = groups
+		return rule
+	}
+	others := []*v1alpha1.MimirRule{
+		pushed("x", "cluster:default:x", "a", "b"),
+		pushed("y", "cluster:default", "y:a"),
+		pushed("z", "cluster:default", "z:a"),
+		pushed("pending", ""),
+	}
+	ns := &rules.RuleNamespace{
+		Namespace: "cluster:default:rule",
+		Groups:    []rwrulefmt.RuleGroup{{RuleGroup: rulefmt.RuleGroup{Name: "a"}}, {RuleGroup: rulefmt.RuleGroup{Name: "b"}}},
+	}
+
+	tests := []struct {
+		name    string
+		markers bool
+		limit   int
+		// wantGroups is the number of rule groups of the tenant
+		wantGroups float64
+		wantErr    bool
+	}{
+		{name: "no limit", wantGroups: 6},
+		{name: "within limit", limit: 6, wantGroups: 6},
+		{name: "over limit", limit: 5, wantGroups: 6, wantErr: true},
+		// The owner groups of cluster:default:x, cluster:default and
+		// cluster:default:rule count as well
+		{name: "markers", markers: true, wantGroups: 9},
+		{name: "markers within limit", markers: true, limit: 9, wantGroups: 9},
+		{name: "markers over limit", markers: true, limit: 6, wantGroups: 9, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config.OwnershipMarkers = tt.markers
+			config.ClusterUID = "uid"
+			config.MaxRuleGroupsPerTenant = tt.limit
+			tc := newTestController(t, config, others...)
+
+			err := tc.checkLimits(newRule("a", "b"), ns)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := testutil.ToFloat64(tc.tenantGroupsGauge.WithLabelValues("tenant")); got != tt.wantGroups {
+				t.Errorf("tenant rule groups = %g, want %g", got, tt.wantGroups)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	kuberr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+// memberLabel labels the membership Leases with the lease lock name of the
+// replicas sharing the shards.
+const memberLabel = "rulescontroller.k8s.healthjoy.com/member-of"
+
+// members keeps a membership Lease for this replica and counts the live
+// replicas sharing the shards, from which the shard quota is derived.
+type members struct {
+	config *Config
+	leases coordinationclient.LeasesGetter
+	count  atomic.Int32
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (m *members) NeedLeaderElection() bool {
+	return false
+}
+
+// Start renews the membership Lease until ctx is cancelled, then deletes it
+// so the other replicas take over the shards right away.
+func (m *members) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, m.heartbeat, m.config.RetryPeriod)
+
+	deleteCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.config.RenewDeadline)
+	defer cancel()
+	err := m.client().Delete(deleteCtx, m.name(), metav1.DeleteOptions{})
+	if err != nil && !kuberr.IsNotFound(err) {
+		klog.ErrorS(err, "Error deleting membership lease", "lease", klog.KRef(m.config.LeaseLockNamespace, m.name()))
+	}
+	return nil
+}
+
+// len returns the number of live replicas, at least 1.
+func (m *members) len() int {
+	if count := int(m.count.Load()); count > 0 {
+		return count
+	}
+	return 1
+}
+
+func (m *members) client() coordinationclient.LeaseInterface {
+	return m.leases.Leases(m.config.LeaseLockNamespace)
+}
+
+func (m *members) name() string {
+	return fmt.Sprintf("%s-member-%s", m.config.LeaseLockName, m.config.PodName)
+}
+
+func (m *members) heartbeat(ctx context.Context) {
+	now := metav1.NewMicroTime(time.Now())
+	lease, err := m.client().Get(ctx, m.name(), metav1.GetOptions{})
+	switch {
+	case kuberr.IsNotFound(err):
+		_, err = m.client().Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   m.name(),
+				Labels: map[string]string{memberLabel: m.config.LeaseLockName},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       ptr.To(m.config.Identity()),
+				LeaseDurationSeconds: ptr.To(int32(m.config.LeaseDuration.Seconds())),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+	case err == nil:
+		lease.Spec.RenewTime = &now
+		_, err = m.client().Update(ctx, lease, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		klog.ErrorS(err, "Error renewing membership lease", "lease", klog.KRef(m.config.LeaseLockNamespace, m.name()))
+		return
+	}
+
+	leases, err := m.client().List(ctx, metav1.ListOptions{LabelSelector: memberLabel + "=" + m.config.LeaseLockName})
+	if err != nil {
+		klog.ErrorS(err, "Error listing membership leases")
+		return
+	}
+	var count int32
+	for i := range leases.Items {
+		lease := &leases.Items[i]
+		if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+		if now.Time.Before(expiry) {
+			count++
+			continue
+		}
+		// Replicas that did not shut down cleanly leave their lease behind
+		if now.Time.Sub(expiry) > m.config.LeaseDuration {
+			if err := m.client().Delete(ctx, lease.Name, metav1.DeleteOptions{}); err != nil && !kuberr.IsNotFound(err) {
+				klog.ErrorS(err, "Error deleting expired membership lease", "lease", klog.KObj(lease))
+			}
+		}
+	}
+	if previous := m.count.Swap(count); previous != count {
+		klog.InfoS("Replicas changed", "replicas", count, "shards", m.config.Shards)
+	}
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+var (
+	managedRulesDesc = prometheus.NewDesc(
+		"mimir_rules_controller_managed_mimirrules",
+		"Number of MimirRules managed by the replica per namespace",
+		[]string{"namespace"}, nil,
+	)
+	managedGroupsDesc = prometheus.NewDesc(
+		"mimir_rules_controller_managed_rule_groups",
+		"Number of rule groups of the MimirRules managed by the replica per namespace",
+		[]string{"namespace"}, nil,
+	)
+	managedRuleCountDesc = prometheus.NewDesc(
+		"mimir_rules_controller_managed_rules",
+		"Number of recording and alerting rules of the MimirRules managed by the replica per namespace",
+		[]string{"namespace"}, nil,
+	)
+	ruleReadyDesc = prometheus.NewDesc(
+		"mimir_rules_controller_rule_ready",
+		"Whether the last sync of the MimirRule pushed its current spec to Mimir",
+		[]string{"namespace", "name"}, nil,
+	)
+)
+
+// ruleCollector exports the state of the MimirRules managed by the replica,
+// read from the informer cache at scrape time. With shards, each replica
+// only exports the rules of the shards it owns.
+type ruleCollector struct {
+	controller *Controller
+}
+
+// Describe implements prometheus.Collector.
+func (rc *ruleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- managedRulesDesc
+	ch <- managedGroupsDesc
+	ch <- managedRuleCountDesc
+	ch <- ruleReadyDesc
+}
+
+// Collect implements prometheus.Collector.
+func (rc *ruleCollector) Collect(ch chan<- prometheus.Metric) {
+	c := rc.controller
+	rules, err := c.rulesLister.List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Error listing rules for the metrics")
+		return
+	}
+
+	type counts struct{ rules, groups, ruleCount int }
+	namespaces := map[string]*counts{}
+	for _, rule := range rules {
+		if !rule.DeletionTimestamp.IsZero() || !c.selects(rule) || !c.shards.owns(rule.Namespace+"/"+rule.Name) {
+			continue
+		}
+		count, ok := namespaces[rule.Namespace]
+		if !ok {
+			count = &counts{}
+			namespaces[rule.Namespace] = count
+		}
+		count.rules++
+		count.groups += len(rule.Spec.Groups)
+		for _, group := range rule.Spec.Groups {
+			count.ruleCount += len(group.Rules)
+		}
+
+		ready := 0.0
+		if ruleReady(rule) {
+			ready = 1
+		}
+		ch <- prometheus.MustNewConstMetric(ruleReadyDesc, prometheus.GaugeValue, ready, rule.Namespace, rule.Name)
+	}
+	for namespace, count := range namespaces {
+		ch <- prometheus.MustNewConstMetric(managedRulesDesc, prometheus.GaugeValue, float64(count.rules), namespace)
+		ch <- prometheus.MustNewConstMetric(managedGroupsDesc, prometheus.GaugeValue, float64(count.groups), namespace)
+		ch <- prometheus.MustNewConstMetric(managedRuleCountDesc, prometheus.GaugeValue, float64(count.ruleCount), namespace)
+	}
+}
+
+// ruleReady reports whether the last sync of rule succeeded and covered its
+// current spec. The status is updated without a status subresource, which
+// bumps the generation once more after the sync.
+func ruleReady(rule *v1alpha1.MimirRule) bool {
+	if apimeta.FindStatusCondition(rule.Status.Conditions, string(v1alpha1.ConditionTypeFailed)) != nil {
+		return false
+	}
+	ready := apimeta.FindStatusCondition(rule.Status.Conditions, string(v1alpha1.ConditionTypeReady))
+	return ready != nil && ready.Status == metav1.ConditionTrue && rule.Generation-ready.ObservedGeneration <= 1
+}
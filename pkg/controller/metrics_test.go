@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+func TestRuleReady(t *testing.T) {
+	ready := func(generation, observed int64, conditions ...metav1.Condition) *v1alpha1.MimirRule {
+		rule := newRule("a")
+		rule.Generation = generation
+		rule.Status.Conditions = append([]metav1.Condition{{
+			Type:               string(v1alpha1.ConditionTypeReady),
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: observed,
+		}}, conditions...)
+		return rule
+	}
+	tests := []struct {
+		name string
+		rule *v1alpha1.MimirRule
+		want bool
+	}{
+		{name: "never synced", rule: newRule("a")},
+		{name: "synced", rule: ready(2, 1), want: true},
+		{name: "synced, same generation", rule: ready(1, 1), want: true},
+		{name: "spec changed", rule: ready(3, 1)},
+		{name: "failed", rule: ready(2, 1, metav1.Condition{Type: string(v1alpha1.ConditionTypeFailed), Status: metav1.ConditionFalse})},
+		{
+			name: "not ready",
+			rule: func() *v1alpha1.MimirRule {
+				rule := ready(2, 1)
+				rule.Status.Conditions[0].Status = metav1.ConditionFalse
+				return rule
+			}(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ruleReady(tt.rule); got != tt.want {
+				t.Errorf("ruleReady() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleCollector(t *testing.T) {
+	synced := newRule("a", "b")
+	synced.Generation = 2
+	synced.Status.Conditions = []metav1.Condition{{
+		Type:               string(v1alpha1.ConditionTypeReady),
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: 1,
+	}}
+	pending := newRule("c")
+	pending.Name = "pending"
+	other := newRule("d")
+	other.Namespace = "other"
+	deleted := newRule("e")
+	deleted.Name = "deleted"
+	deleted.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	unselected := newRule("f")
+	unselected.Name = "unselected"
+	unselected.Spec.ControllerName = "other-controller"
+
+	tc := newTestController(t, testConfig(), synced, pending, other, deleted, unselected)
+	want := `
+# HELP mimir_rules_controller_managed_mimirrules Number of MimirRules managed by the replica per namespace
+# TYPE mimir_rules_controller_managed_mimirrules gauge
+mimir_rules_controller_managed_mimirrules{namespace="default"} 2
+mimir_rules_controller_managed_mimirrules{namespace="other"} 1
+# HELP mimir_rules_controller_managed_rule_groups Number of rule groups of the MimirRules managed by the replica per namespace
+# TYPE mimir_rules_controller_managed_rule_groups gauge
+mimir_rules_controller_managed_rule_groups{namespace="default"} 3
+mimir_rules_controller_managed_rule_groups{namespace="other"} 1
+# HELP mimir_rules_controller_managed_rules Number of recording and alerting rules of the MimirRules managed by the replica per namespace
+# TYPE mimir_rules_controller_managed_rules gauge
+mimir_rules_controller_managed_rules{namespace="default"} 3
+mimir_rules_controller_managed_rules{namespace="other"} 1
+# HELP mimir_rules_controller_rule_ready Whether the last sync of the MimirRule pushed its current spec to Mimir
+# TYPE mimir_rules_controller_rule_ready gauge
+mimir_rules_controller_rule_ready{name="pending",namespace="default"} 0
+mimir_rules_controller_rule_ready{name="rule",namespace="default"} 1
+mimir_rules_controller_rule_ready{name="rule",namespace="other"} 0
+`
+	if err := testutil.CollectAndCompare(&ruleCollector{controller: tc.Controller}, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// A replica only exports the rules of the shards it owns
+	tc.shards = newShards(tc.config.Load().Shards)
+	if n := testutil.CollectAndCount(&ruleCollector{controller: tc.Controller}); n != 0 {
+		t.Errorf("collected %d metrics without shards, want none", n)
+	}
+}
+
+// TestSyncMetrics checks the sync metrics labeled by namespace and result.
+func TestSyncMetrics(t *testing.T) {
+	tc := newTestController(t, testConfig(), newRule("a"))
+	if _, err := tc.reconcile(); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if got := testutil.ToFloat64(tc.syncCounter.WithLabelValues("default", "success")); got != 1 {
+		t.Errorf("successful syncs = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(tc.syncCounter.WithLabelValues("default", "error")); got != 0 {
+		t.Errorf("failed syncs = %g, want 0", got)
+	}
+	if got := testutil.CollectAndCount(tc.syncHistogram); got != 1 {
+		t.Errorf("sync duration has %d series, want 1", got)
+	}
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// mimirCheckTimeout bounds a single connectivity check of Mimir.
+const mimirCheckTimeout = 10 * time.Second
+
+// mimirStatus is the result of the last connectivity check of Mimir.
+type mimirStatus struct {
+	err  error
+	time time.Time
+}
+
+// CheckMimir checks that the ruler API of Mimir can be reached and records
+// the result, which pauses the rules while Mimir is down.
+func (c *Controller) CheckMimir(ctx context.Context) error {
+	checkCtx, cancel := context.WithTimeout(ctx, mimirCheckTimeout)
+	defer cancel()
+
+	err := c.mimirclient.Ping(checkCtx)
+	if ctx.Err() != nil {
+		// Shutting down, the result says nothing about Mimir
+		return ctx.Err()
+	}
+	previous := c.mimirStatus.Swap(&mimirStatus{err: err, time: time.Now()})
+	wasUp := previous == nil || previous.err == nil
+	if err != nil {
+		c.mimirUpGauge.Set(0)
+		if wasUp {
+			klog.ErrorS(err, "Mimir ruler API is not reachable, pausing rules")
+		}
+		return err
+	}
+	c.mimirUpGauge.Set(1)
+	c.mimirLastSuccessGauge.SetToCurrentTime()
+	if !wasUp {
+		klog.InfoS("Mimir ruler API is reachable again, resuming rules")
+	}
+	return nil
+}
+
+// mimirUp reports whether the last connectivity check of Mimir succeeded.
+// Rules are never paused when the periodic check is disabled, since nothing
+// would resume them.
+func (c *Controller) mimirUp() bool {
+	if c.config.Load().MimirCheckInterval <= 0 {
+		return true
+	}
+	status := c.mimirStatus.Load()
+	return status == nil || status.err == nil
+}
+
+// MimirReachable is the readiness entry of Mimir. It never fails: every
+// replica depends on the same Mimir, so an outage would take them all out of
+// the Service at once, and the rules are already paused while Mimir is down.
+// The result of the connectivity check is exported by the mimir_up metric.
+func (c *Controller) MimirReachable(*http.Request) error {
+	return nil
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/grafana/mimir/pkg/mimirtool/client"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+	"github.com/healthjoy/mimir-rules-controller/pkg/tracing"
+)
+
+// DefaultNamespaceTemplate is the template used to name Mimir namespaces when
+// none is configured. It keeps one Mimir namespace per MimirRule.
+const DefaultNamespaceTemplate = "{{.Cluster}}:{{.Namespace}}:{{.Name}}"
+
+// NamespaceTemplateData is the data available to the Mimir namespace template.
+type NamespaceTemplateData struct {
+	// Cluster is the configured cluster name.
+	Cluster string
+	// Namespace is the Kubernetes namespace of the MimirRule.
+	Namespace string
+	// Name is the name of the MimirRule.
+	Name string
+	// Labels are the labels of the MimirRule. A missing label renders as an
+	// empty string, e.g. {{or .Labels.team "none"}} provides a fallback.
+	Labels map[string]string
+	// Annotations are the annotations of the MimirRule. A missing annotation
+	// renders as an empty string.
+	Annotations map[string]string
+}
+
+// parseNamespaceTemplate parses a Mimir namespace template. Missing labels
+// and annotations render as empty strings, unknown fields are errors.
+func parseNamespaceTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultNamespaceTemplate
+	}
+	tmpl, err := template.New("namespace").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace template: %w", err)
+	}
+	// Render a sample to catch references to unknown fields early. The sample
+	// has no labels nor annotations, like many MimirRules.
+	if _, err := executeNamespaceTemplate(tmpl, NamespaceTemplateData{
+		Cluster:     "cluster",
+		Namespace:   "namespace",
+		Name:        "name",
+		Labels:      map[string]string{},
+		Annotations: map[string]string{},
+	}); err != nil && !errors.Is(err, errEmptyNamespace) {
+		return nil, fmt.Errorf("invalid namespace template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// errEmptyNamespace is returned when the namespace template of a MimirRule
+// renders an empty name, e.g. from a missing label.
+var errEmptyNamespace = errors.New("namespace template rendered an empty name")
+
+func executeNamespaceTemplate(tmpl *template.Template, data NamespaceTemplateData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	if buf.Len() == 0 {
+		return "", errEmptyNamespace
+	}
+	return buf.String(), nil
+}
+
+// MimirNamespace returns the name of the Mimir namespace holding the rule
+// groups of the given rule, using the template of its policy if any.
+func (c *Config) MimirNamespace(rule *v1alpha1.MimirRule) (string, error) {
+	if c.aggregateNamespaces(rule.Namespace) {
+		return c.ClusterName + ":" + rule.Namespace, nil
+	}
+	tmpl := c.namespaceTemplate
+	if p := c.policy(rule.Namespace); p != nil && p.namespaceTemplate != nil {
+		tmpl = p.namespaceTemplate
+	}
+	if tmpl == nil {
+		var err error
+		if tmpl, err = parseNamespaceTemplate(c.NamespaceTemplate); err != nil {
+			return "", err
+		}
+	}
+	return executeNamespaceTemplate(tmpl, NamespaceTemplateData{
+		Cluster:     c.ClusterName,
+		Namespace:   rule.Namespace,
+		Name:        rule.Name,
+		Labels:      rule.Labels,
+		Annotations: rule.Annotations,
+	})
+}
+
+// GroupName returns the name of the Mimir rule group holding the given group
+// of rule. When the MimirRules of a Kubernetes namespace are aggregated, the
+// group is prefixed with the name of the MimirRule, which cannot contain a
+// colon, so the groups of different MimirRules never collide.
+func (c *Config) GroupName(rule *v1alpha1.MimirRule, group string) string {
+	if c.aggregateNamespaces(rule.Namespace) {
+		return rule.Name + ":" + group
+	}
+	return group
+}
+
+// groupNames returns the names of the Mimir rule groups of rule.
+func (c *Config) groupNames(rule *v1alpha1.MimirRule) []string {
+	names := make([]string, 0, len(rule.Spec.Groups))
+	for _, group := range rule.Spec.Groups {
+		names = append(names, c.GroupName(rule, group.Name))
+	}
+	return names
+}
+
+// deleteStaleGroups removes the groups recorded in the rule status that are
+// no longer part of the desired Mimir namespace, e.g. because the namespace
+// template changed or a group was removed from the spec, and releases the
+// Mimir namespace rule leaves.
+func (c *Controller) deleteStaleGroups(ctx context.Context, rule *v1alpha1.MimirRule, namespace string, groups []string) error {
+	if rule.Status.MimirNamespace == "" {
+		return nil
+	}
+	desired := make(map[string]struct{}, len(groups))
+	for _, group := range groups {
+		desired[group] = struct{}{}
+	}
+	var stale []string
+	for _, group := range rule.Status.Groups {
+		if _, ok := desired[group]; ok && rule.Status.MimirNamespace == namespace {
+			continue
+		}
+		stale = append(stale, group)
+	}
+	if err := c.deleteGroups(ctx, rule, rule.Status.MimirNamespace, stale); err != nil {
+		return err
+	}
+	if rule.Status.MimirNamespace != namespace || len(groups) == 0 {
+		return c.releaseNamespace(ctx, rule, rule.Status.MimirNamespace)
+	}
+	return nil
+}
+
+// deleteGroups deletes the given groups of rule from the Mimir namespace.
+// The groups of a namespace owned by another controller or cluster are left
+// alone and reported with a Warning Event.
+func (c *Controller) deleteGroups(ctx context.Context, rule *v1alpha1.MimirRule, namespace string, groups []string) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	logger := klog.FromContext(ctx)
+	if _, err := c.checkOwner(ctx, namespace); err != nil {
+		if !isNotOwner(err) {
+			return err
+		}
+		logger.Info("Not deleting rule groups from a Mimir namespace owned by another controller", "groups", groups, "err", err.Error())
+		c.eventf(ctx, rule, corev1.EventTypeWarning, ReasonOwnershipConflict, "Did not delete rule groups '%s': %s", strings.Join(groups, "', '"), err.Error())
+		return nil
+	}
+	for _, group := range groups {
+		logger.Info("Deleting rule group", "group", group, "deletedMimirNamespace", namespace)
+		groupCtx, span := tracing.Start(ctx, "DeleteRuleGroup", tracing.MimirNamespace.String(namespace), tracing.RuleGroup.String(group))
+		err := c.mimirclient.DeleteRuleGroup(groupCtx, namespace, group)
+		tracing.End(span, err)
+		if err != nil && !errors.Is(err, client.ErrResourceNotFound) {
+			return fmt.Errorf("error deleting rule group '%s' from namespace '%s': %w", group, namespace, err)
+		}
+		c.eventf(ctx, rule, corev1.EventTypeNormal, ReasonGroupDeleted, "Deleted rule group '%s' from Mimir namespace '%s'", group, namespace)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"slices"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+func TestMimirNamespace(t *testing.T) {
+	labeled := &v1alpha1.MimirRule{ObjectMeta: metav1.ObjectMeta{
+		Name:        "rule",
+		Namespace:   "default",
+		Labels:      map[string]string{"team": "a"},
+		Annotations: map[string]string{"owner": "b"},
+	}}
+	bare := &v1alpha1.MimirRule{ObjectMeta: metav1.ObjectMeta{Name: "rule", Namespace: "default"}}
+
+	tests := []struct {
+		name     string
+		template string
+		rule     *v1alpha1.MimirRule
+		want     string
+		// wantParseErr is set when the template is rejected by Validate
+		wantParseErr bool
+		wantErr      bool
+	}{
+		{name: "default", rule: bare, want: "cluster:default:rule"},
+		{name: "label", template: "{{.Labels.team}}:{{.Name}}", rule: labeled, want: "a:rule"},
+		{name: "annotation", template: "{{.Annotations.owner}}:{{.Name}}", rule: labeled, want: "b:rule"},
+		{name: "missing label", template: "{{.Cluster}}:{{.Labels.team}}:{{.Name}}", rule: bare, want: "cluster::rule"},
+		{name: "missing label fallback", template: `{{or .Labels.team "shared"}}:{{.Name}}`, rule: bare, want: "shared:rule"},
+		{name: "only a label", template: "{{.Labels.team}}", rule: labeled, want: "a"},
+		{name: "only a missing label", template: "{{.Labels.team}}", rule: bare, wantErr: true},
+		{name: "unknown field", template: "{{.Team}}", wantParseErr: true},
+		{name: "syntax error", template: "{{.Name", wantParseErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{ClusterName: "cluster", NamespaceTemplate: tt.template}
+			tmpl, err := parseNamespaceTemplate(tt.template)
+			if (err != nil) != tt.wantParseErr {
+				t.Fatalf("parseNamespaceTemplate() error = %v, wantParseErr %v", err, tt.wantParseErr)
+			}
+			if tt.wantParseErr {
+				return
+			}
+			config.namespaceTemplate = tmpl
+			got, err := config.MimirNamespace(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MimirNamespace() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MimirNamespace() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupName(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name      string
+		aggregate bool
+		policies  []Policy
+		namespace string
+		wantNs    string
+		want      []string
+	}{
+		{name: "one namespace per rule", namespace: "default", wantNs: "cluster:default:rule", want: []string{"a", "b"}},
+		{name: "aggregated", aggregate: true, namespace: "default", wantNs: "cluster:default", want: []string{"rule:a", "rule:b"}},
+		{
+			name:      "aggregated by policy",
+			policies:  []Policy{{Name: "team", Namespaces: []string{"team-*"}, AggregateNamespaces: &enabled}},
+			namespace: "team-a",
+			wantNs:    "cluster:team-a",
+			want:      []string{"rule:a", "rule:b"},
+		},
+		{
+			name:      "not aggregated by policy",
+			aggregate: true,
+			policies:  []Policy{{Name: "team", Namespaces: []string{"team-*"}, AggregateNamespaces: &disabled}},
+			namespace: "team-a",
+			wantNs:    "cluster:team-a:rule",
+			want:      []string{"a", "b"},
+		},
+		{
+			name:      "other policy",
+			aggregate: true,
+			policies:  []Policy{{Name: "team", Namespaces: []string{"team-*"}, AggregateNamespaces: &disabled}},
+			namespace: "default",
+			wantNs:    "cluster:default",
+			want:      []string{"rule:a", "rule:b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{ClusterName: "cluster", AggregateNamespaces: tt.aggregate, Policies: tt.policies}
+			rule := &v1alpha1.MimirRule{
+				ObjectMeta: metav1.ObjectMeta{Name: "rule", Namespace: tt.namespace},
+				Spec:       v1alpha1.RuleSpec{Groups: []v1alpha1.RuleGroup{{Name: "a"}, {Name: "b"}}},
+			}
+			ns, err := config.MimirNamespace(rule)
+			if err != nil {
+				t.Fatalf("MimirNamespace() error = %v", err)
+			}
+			if ns != tt.wantNs {
+				t.Errorf("MimirNamespace() = %q, want %q", ns, tt.wantNs)
+			}
+			if got := config.groupNames(rule); !slices.Equal(got, tt.want) {
+				t.Errorf("groupNames() = %v, want %v", got, tt.want)
+			}
+			if got := config.GroupName(rule, "a"); got != tt.want[0] {
+				t.Errorf("GroupName() = %q, want %q", got, tt.want[0])
+			}
+		})
+	}
+}
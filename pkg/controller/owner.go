@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/grafana/mimir/pkg/mimirtool/client"
+	"github.com/grafana/mimir/pkg/mimirtool/rules/rwrulefmt"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+	"github.com/healthjoy/mimir-rules-controller/pkg/tracing"
+)
+
+// OwnerGroup is the rule group marking the owner of a Mimir namespace. The
+// controller adds it to every Mimir namespace it pushes rule groups to, and
+// does not touch the rule groups of namespaces marked by another controller
+// or cluster. The name is reserved.
+const OwnerGroup = "mimir_rules_controller_owner"
+
+// ownerAlert is the only rule of the owner group. Mimir rejects empty rule
+// groups, and the labels of the rule identify the owner. It is an alerting
+// rule that never fires, so the marker writes no series.
+const (
+	ownerAlert = "MimirRulesControllerOwner"
+	ownerExpr  = "vector(1) < 0"
+)
+
+// Labels of the owner group rule.
+const (
+	ownerControllerLabel = "controller"
+	ownerClusterLabel    = "cluster"
+	ownerClusterUIDLabel = "cluster_uid"
+)
+
+// notOwnerError is returned for a Mimir namespace owned by another
+// controller or cluster.
+type notOwnerError struct {
+	namespace string
+	owner     map[string]string
+}
+
+func (e *notOwnerError) Error() string {
+	return fmt.Sprintf("Mimir namespace '%s' is owned by controller '%s' of cluster '%s' (UID %s)",
+		e.namespace, e.owner[ownerControllerLabel], e.owner[ownerClusterLabel], e.owner[ownerClusterUIDLabel])
+}
+
+// isNotOwner reports whether err is caused by a Mimir namespace owned by
+// another controller or cluster.
+func isNotOwner(err error) bool {
+	var notOwnerErr *notOwnerError
+	return errors.As(err, &notOwnerErr)
+}
+
+// ownerLabels returns the labels identifying the controller as an owner.
+func (c *Config) ownerLabels() map[string]string {
+	return map[string]string{
+		ownerControllerLabel: c.ControllerName,
+		ownerClusterLabel:    c.ClusterName,
+		ownerClusterUIDLabel: c.ClusterUID,
+	}
+}
+
+// ownerGroup returns the owner group of the controller.
+func (c *Config) ownerGroup() rwrulefmt.RuleGroup {
+	return rwrulefmt.RuleGroup{RuleGroup: rulefmt.RuleGroup{
+		Name: OwnerGroup,
+		Rules: []rulefmt.RuleNode{{
+			Alert:  yaml.Node{Kind: yaml.ScalarNode, Value: ownerAlert},
+			Expr:   yaml.Node{Kind: yaml.ScalarNode, Value: ownerExpr},
+			Labels: c.ownerLabels(),
+		}},
+	}}
+}
+
+// owns reports whether the owner group of a Mimir namespace was written by
+// the controller. The cluster name is informative, the cluster UID tells
+// clusters sharing a name apart.
+func (c *Config) owns(group *rwrulefmt.RuleGroup) (bool, map[string]string) {
+	var owner map[string]string
+	if len(group.Rules) > 0 {
+		owner = group.Rules[0].Labels
+	}
+	return owner[ownerControllerLabel] == c.ControllerName && owner[ownerClusterUIDLabel] == c.ClusterUID, owner
+}
+
+// checkOwner returns a notOwnerError when the Mimir namespace is marked by
+// another controller or cluster. It returns whether the namespace is marked.
+func (c *Controller) checkOwner(ctx context.Context, namespace string) (bool, error) {
+	config := c.config.Load()
+	if !config.OwnershipMarkers {
+		return false, nil
+	}
+	ctx, span := tracing.Start(ctx, "CheckOwner", tracing.MimirNamespace.String(namespace))
+	group, err := c.mimirclient.GetRuleGroup(ctx, namespace, OwnerGroup)
+	tracing.End(span, err)
+	if errors.Is(err, client.ErrResourceNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting the owner of Mimir namespace '%s': %w", namespace, err)
+	}
+	if owned, owner := config.owns(group); !owned {
+		return true, &notOwnerError{namespace: namespace, owner: owner}
+	}
+	return true, nil
+}
+
+// claimNamespace marks the Mimir namespace as owned by the controller unless
+// it already is. It returns a notOwnerError when the namespace is marked by
+// another controller or cluster. Namespaces written before the markers were
+// introduced are claimed by the first controller syncing them.
+func (c *Controller) claimNamespace(ctx context.Context, namespace string) error {
+	marked, err := c.checkOwner(ctx, namespace)
+	if err != nil || marked || !c.config.Load().OwnershipMarkers {
+		return err
+	}
+	ctx, span := tracing.Start(ctx, "CreateRuleGroup", tracing.MimirNamespace.String(namespace), tracing.RuleGroup.String(OwnerGroup))
+	err = c.mimirclient.CreateRuleGroup(ctx, namespace, c.config.Load().ownerGroup())
+	tracing.End(span, err)
+	if err != nil {
+		return fmt.Errorf("error marking the owner of Mimir namespace '%s': %w", namespace, err)
+	}
+	klog.FromContext(ctx).V(2).Info("Claimed Mimir namespace", "claimedMimirNamespace", namespace)
+	return nil
+}
+
+// releaseNamespace removes the owner group of a Mimir namespace rule no
+// longer pushes rule groups to, unless another MimirRule still does.
+func (c *Controller) releaseNamespace(ctx context.Context, rule *v1alpha1.MimirRule, namespace string) error {
+	if !c.config.Load().OwnershipMarkers {
+		return nil
+	}
+	others, err := c.rulesLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, other := range others {
+		if other.UID != rule.UID && other.Status.MimirNamespace == namespace && len(other.Status.Groups) > 0 {
+			return nil
+		}
+	}
+	// Never remove the marker of another owner
+	marked, err := c.checkOwner(ctx, namespace)
+	if isNotOwner(err) || err == nil && !marked {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ctx, span := tracing.Start(ctx, "DeleteRuleGroup", tracing.MimirNamespace.String(namespace), tracing.RuleGroup.String(OwnerGroup))
+	err = c.mimirclient.DeleteRuleGroup(ctx, namespace, OwnerGroup)
+	tracing.End(span, err)
+	if err != nil && !errors.Is(err, client.ErrResourceNotFound) {
+		return fmt.Errorf("error deleting the owner of Mimir namespace '%s': %w", namespace, err)
+	}
+	klog.FromContext(ctx).V(2).Info("Released Mimir namespace", "releasedMimirNamespace", namespace)
+	return nil
+}
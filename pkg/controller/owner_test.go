@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/mimir/pkg/mimirtool/rules"
+	"github.com/grafana/mimir/pkg/mimirtool/rules/rwrulefmt"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+	"github.com/healthjoy/mimir-rules-controller/pkg/ruletest"
+)
+
+func TestOwnerGroup(t *testing.T) {
+	config := &Config{ControllerName: "controller", ClusterName: "cluster", ClusterUID: "uid"}
+	group := config.ownerGroup()
+
+	if errs := rules.ValidateRuleGroup(group); len(errs) > 0 {
+		t.Fatalf("owner group is invalid: %v", errs)
+	}
+	for _, rule := range group.Rules {
+		if rule.Record.Value != "" {
+			t.Errorf("owner group records %s, it must write no series", rule.Record.Value)
+		}
+	}
+
+	// The alert never fires
+	ns := &rules.RuleNamespace{Namespace: "namespace", Groups: []rwrulefmt.RuleGroup{group}}
+	test := v1alpha1.RuleTest{
+		AlertRuleTests: []v1alpha1.AlertRuleTest{{EvalTime: "0m", Alertname: ownerAlert}, {EvalTime: "10m", Alertname: ownerAlert}},
+	}
+	if errs := ruletest.Run(context.Background(), ns, []v1alpha1.RuleTest{test}, ruletest.DefaultLimits); len(errs) > 0 {
+		t.Errorf("owner alert fires: %v", errs)
+	}
+
+	tests := []struct {
+		name   string
+		config *Config
+		want   bool
+	}{
+		{name: "same controller", config: config, want: true},
+		{name: "other cluster name", config: &Config{ControllerName: "controller", ClusterName: "other", ClusterUID: "uid"}, want: true},
+		{name: "other cluster", config: &Config{ControllerName: "controller", ClusterName: "cluster", ClusterUID: "other"}},
+		{name: "other controller", config: &Config{ControllerName: "other", ClusterName: "cluster", ClusterUID: "uid"}},
+	}
+	for _, tt := range tests {
+		if got, _ := tt.config.owns(&group); got != tt.want {
+			t.Errorf("%s: owns() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
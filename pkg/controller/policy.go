@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"text/template"
+)
+
+// Policy overrides the default settings for the MimirRules it matches. The
+// first policy matching a MimirRule applies.
+type Policy struct {
+	// Name identifies the policy in errors.
+	Name string `yaml:"name"`
+	// Namespaces are shell patterns matched against the Kubernetes namespace
+	// of the MimirRules, e.g. "team-*". Empty matches every namespace.
+	Namespaces []string `yaml:"namespaces,omitempty"`
+	// Tenants are the Mimir tenants the policy applies to, so one file can
+	// serve controllers of several tenants. Empty matches every tenant.
+	Tenants []string `yaml:"tenants,omitempty"`
+
+	// NamespaceTemplate overrides Config.NamespaceTemplate.
+	NamespaceTemplate string `yaml:"namespaceTemplate,omitempty"`
+	// DetectDuplicateAlerts overrides Config.DetectDuplicateAlerts.
+	DetectDuplicateAlerts *bool `yaml:"detectDuplicateAlerts,omitempty"`
+	// UnselectedPolicy overrides Config.UnselectedPolicy.
+	UnselectedPolicy string `yaml:"unselectedPolicy,omitempty"`
+	// AggregateNamespaces overrides Config.AggregateNamespaces.
+	AggregateNamespaces *bool `yaml:"aggregateNamespaces,omitempty"`
+
+	namespaceTemplate *template.Template
+}
+
+// validate checks the policy and prepares it for use.
+func (p *Policy) validate() error {
+	var errs []error
+	for _, pattern := range p.Namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("invalid namespace pattern %q: %w", pattern, err))
+		}
+	}
+	switch p.UnselectedPolicy {
+	case "", UnselectedPolicyDelete, UnselectedPolicyRetain:
+	default:
+		errs = append(errs, fmt.Errorf("unselected policy must be %q or %q, got %q", UnselectedPolicyDelete, UnselectedPolicyRetain, p.UnselectedPolicy))
+	}
+	if p.NamespaceTemplate != "" {
+		tmpl, err := parseNamespaceTemplate(p.NamespaceTemplate)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		p.namespaceTemplate = tmpl
+	}
+	return errors.Join(errs...)
+}
+
+// matches reports whether the policy applies to the MimirRules of namespace
+// pushed to tenant.
+func (p *Policy) matches(namespace, tenant string) bool {
+	if len(p.Tenants) > 0 && !contains(p.Tenants, tenant) {
+		return false
+	}
+	if len(p.Namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range p.Namespaces {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// policy returns the policy of the MimirRules of namespace, or nil when none
+// matches.
+func (c *Config) policy(namespace string) *Policy {
+	for i := range c.Policies {
+		if c.Policies[i].matches(namespace, c.Tenant) {
+			return &c.Policies[i]
+		}
+	}
+	return nil
+}
+
+// detectDuplicateAlerts reports whether duplicate alert names are reported
+// in namespace.
+func (c *Config) detectDuplicateAlerts(namespace string) bool {
+	if p := c.policy(namespace); p != nil && p.DetectDuplicateAlerts != nil {
+		return *p.DetectDuplicateAlerts
+	}
+	return c.DetectDuplicateAlerts
+}
+
+// unselectedPolicy returns the unselected policy of the MimirRules of
+// namespace.
+func (c *Config) unselectedPolicy(namespace string) string {
+	if p := c.policy(namespace); p != nil && p.UnselectedPolicy != "" {
+		return p.UnselectedPolicy
+	}
+	return c.UnselectedPolicy
+}
+
+// aggregateNamespaces reports whether the MimirRules of namespace are merged
+// into one Mimir namespace.
+func (c *Config) aggregateNamespaces(namespace string) bool {
+	if p := c.policy(namespace); p != nil && p.AggregateNamespaces != nil {
+		return *p.AggregateNamespaces
+	}
+	return c.AggregateNamespaces
+}
@@ -0,0 +1,203 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	kuberr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+// selects reports whether rule is managed by this controller instance
+// according to its controller name, the watched namespaces and the selectors.
+func (c *Controller) selects(rule *v1alpha1.MimirRule) bool {
+	config := c.config.Load()
+	if rule.Spec.ControllerName == "" {
+		if !config.DefaultController {
+			return false
+		}
+	} else if rule.Spec.ControllerName != config.ControllerName {
+		return false
+	}
+	if len(config.WatchNamespaces) > 0 && !contains(config.WatchNamespaces, rule.Namespace) {
+		return false
+	}
+	if config.ruleSelector != nil && !config.ruleSelector.Matches(labels.Set(rule.Labels)) {
+		return false
+	}
+	if c.namespaceLister == nil || config.namespaceSelector == nil || config.namespaceSelector.Empty() {
+		return true
+	}
+	namespace, err := c.namespaceLister.Get(rule.Namespace)
+	if err != nil {
+		if !kuberr.IsNotFound(err) {
+			klog.ErrorS(err, "Error getting namespace", "namespace", rule.Namespace)
+		}
+		return false
+	}
+	return config.namespaceSelector.Matches(labels.Set(namespace.Labels))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// markUnselected remembers that the rule with the given key left the
+// selectors, so its next sync releases it.
+func (c *Controller) markUnselected(key string) {
+	c.unselected.Store(key, struct{}{})
+}
+
+// enqueueSelected enqueues the rule if it is managed by this controller
+// instance.
+func (c *Controller) enqueueSelected(queue workqueue.RateLimitingInterface, obj interface{}) {
+	if rule, ok := ruleFromObject(obj); ok && c.selects(rule) {
+		c.enqueueRule(queue, rule)
+	}
+}
+
+// enqueueUnselected enqueues the rule for release if an update moved it out
+// of the selectors.
+func (c *Controller) enqueueUnselected(queue workqueue.RateLimitingInterface, old, new interface{}) {
+	oldRule, ok := ruleFromObject(old)
+	if !ok {
+		return
+	}
+	newRule, ok := ruleFromObject(new)
+	if !ok || !c.selects(oldRule) || c.selects(newRule) {
+		return
+	}
+	c.markUnselected(newRule.Namespace + "/" + newRule.Name)
+	c.enqueueRule(queue, newRule)
+}
+
+// enqueueDeleted enqueues a rule removed from the informer for release.
+// When the rule selector is applied by the API server, a rule whose labels
+// stop matching is seen as deleted although it still exists, and the event
+// carries its old labels, which still match. Whether the rule is gone, still
+// selected or has to be released is only known from the API server, which
+// releaseRule asks.
+func (c *Controller) enqueueDeleted(queue workqueue.RateLimitingInterface, obj interface{}) {
+	rule, ok := ruleFromObject(obj)
+	if !ok {
+		return
+	}
+	c.markUnselected(rule.Namespace + "/" + rule.Name)
+	c.enqueueRule(queue, rule)
+}
+
+func ruleFromObject(obj interface{}) (*v1alpha1.MimirRule, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	rule, ok := obj.(*v1alpha1.MimirRule)
+	if !ok {
+		klog.ErrorS(nil, "Expected a MimirRule", "type", fmt.Sprintf("%T", obj))
+	}
+	return rule, ok
+}
+
+// enqueueNamespace enqueues the MimirRules of a namespace whose labels moved
+// it in or out of the namespace selector.
+func (c *Controller) enqueueNamespace(queue workqueue.RateLimitingInterface, old, new interface{}) {
+	oldNamespace, ok := old.(*corev1.Namespace)
+	if !ok {
+		return
+	}
+	newNamespace, ok := new.(*corev1.Namespace)
+	if !ok {
+		return
+	}
+	selector := c.config.Load().namespaceSelector
+	wasSelected := selector.Matches(labels.Set(oldNamespace.Labels))
+	if wasSelected == selector.Matches(labels.Set(newNamespace.Labels)) {
+		return
+	}
+
+	rules, err := c.rulesLister.MimirRules(newNamespace.Name).List(labels.Everything())
+	if err != nil {
+		klog.ErrorS(err, "Error listing rules", "namespace", newNamespace.Name)
+		return
+	}
+	for _, rule := range rules {
+		if wasSelected {
+			c.markUnselected(rule.Namespace + "/" + rule.Name)
+		}
+		c.enqueueRule(queue, rule)
+	}
+}
+
+// syncUnselected releases the rule with the given key if it left the
+// selectors. Rules that were never selected are left untouched.
+func (c *Controller) syncUnselected(ctx context.Context, key, namespace, name string) error {
+	if _, ok := c.unselected.Load(key); !ok {
+		return nil
+	}
+	if err := c.releaseRule(ctx, namespace, name); err != nil {
+		klog.FromContext(ctx).Error(err, "Error releasing rule")
+		return err
+	}
+	c.unselected.Delete(key)
+	return nil
+}
+
+// releaseRule hands a MimirRule that left the selectors back: depending on
+// the unselected policy its rule groups are deleted from Mimir, then the
+// status and finalizer of the controller are removed. The rule is read from
+// the API server since the informer may no longer hold it.
+func (c *Controller) releaseRule(ctx context.Context, namespace, name string) error {
+	rule, err := c.rulesclientset.RulescontrollerV1alpha1().MimirRules(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		if kuberr.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if c.selects(rule) || !controllerutil.ContainsFinalizer(rule, v1alpha1.RuleFinalizer) {
+		return nil
+	}
+
+	key := namespace + "/" + name
+	logger := klog.FromContext(ctx)
+	config := c.config.Load()
+	policy := config.unselectedPolicy(rule.Namespace)
+	if policy == UnselectedPolicyDelete {
+		logger.Info("Rule no longer matches the selectors, deleting its rule groups")
+		if rule.Status.MimirNamespace == "" {
+			mimirNamespace, err := config.MimirNamespace(rule)
+			if err != nil {
+				return fmt.Errorf("error building mimir namespace for rule '%s': %w", key, err)
+			}
+			if err := c.deleteGroups(ctx, rule, mimirNamespace, config.groupNames(rule)); err != nil {
+				return fmt.Errorf("error deleting rule groups for rule '%s': %w", key, err)
+			}
+		}
+		if err := c.deleteStaleGroups(ctx, rule, "", nil); err != nil {
+			return fmt.Errorf("error deleting rule groups for rule '%s': %w", key, err)
+		}
+	} else {
+		logger.Info("Rule no longer matches the selectors, retaining its rule groups")
+	}
+
+	rule.Status.MimirNamespace = ""
+	rule.Status.Groups = nil
+	controllerutil.RemoveFinalizer(rule, v1alpha1.RuleFinalizer)
+	if _, err := c.rulesclientset.RulescontrollerV1alpha1().MimirRules(namespace).Update(ctx, rule, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("error releasing rule '%s': %w", key, err)
+	}
+	c.eventf(ctx, rule, corev1.EventTypeNormal, ReasonReleased, "Rule no longer matches the selectors of controller '%s', released it with the %s policy", config.ControllerName, policy)
+	return nil
+}
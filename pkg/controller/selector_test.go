@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+	"github.com/healthjoy/mimir-rules-controller/pkg/generated/clientset/versioned/fake"
+	rulesinformers "github.com/healthjoy/mimir-rules-controller/pkg/generated/informers/externalversions"
+)
+
+func testRule(team string) *v1alpha1.MimirRule {
+	return &v1alpha1.MimirRule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "rule",
+			Namespace:  "default",
+			Labels:     map[string]string{"team": team},
+			Finalizers: []string{v1alpha1.RuleFinalizer},
+		},
+		Status: v1alpha1.RuleStatus{MimirNamespace: "cluster:default:rule", Groups: []string{"group"}},
+	}
+}
+
+// TestEnqueueDeletedServerSideSelector checks that a rule dropped by the
+// informer because its labels stopped matching the rule selector applied by
+// the API server is released, although the event carries the old labels.
+func TestEnqueueDeletedServerSideSelector(t *testing.T) {
+	tests := []struct {
+		name string
+		// current is the rule held by the API server, nil if deleted
+		current      *v1alpha1.MimirRule
+		tombstone    bool
+		wantReleased bool
+	}{
+		{name: "labels changed", current: testRule("b"), wantReleased: true},
+		{name: "labels changed, tombstone", current: testRule("b"), tombstone: true, wantReleased: true},
+		{name: "still selected", current: testRule("a")},
+		{name: "deleted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			config := Config{
+				DefaultController: true,
+				RuleSelector:      "team=a",
+				UnselectedPolicy:  UnselectedPolicyRetain,
+				Shards:            1,
+			}
+			var err error
+			if config.ruleSelector, err = labels.Parse(config.RuleSelector); err != nil {
+				t.Fatal(err)
+			}
+
+			// The informer lists the rule while it matches the selector
+			client := fake.NewSimpleClientset(testRule("a"))
+			factory := rulesinformers.NewSharedInformerFactoryWithOptions(client, 0, config.RuleInformerOptions()...)
+			informer := factory.Rulescontroller().V1alpha1().MimirRules()
+			informer.Informer()
+			stop := make(chan struct{})
+			factory.Start(stop)
+			factory.WaitForCacheSync(stop)
+			// The fake clientset does not filter watches by label, stop the
+			// informer before the labels change
+			close(stop)
+			old, err := informer.Lister().MimirRules("default").Get("rule")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := &Controller{
+				rulesclientset: client,
+				rulesLister:    informer.Lister(),
+				shards:         newShards(1),
+				recorder:       record.NewFakeRecorder(10),
+			}
+			c.config.Store(&config)
+			c.shards.acquire(ctx, 0)
+
+			// Then the API server changes or deletes the rule and the informer
+			// sees it deleted
+			if tt.current != nil {
+				if _, err := client.RulescontrollerV1alpha1().MimirRules("default").Update(ctx, tt.current, metav1.UpdateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := client.RulescontrollerV1alpha1().MimirRules("default").Delete(ctx, "rule", metav1.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if err := informer.Informer().GetIndexer().Delete(old); err != nil {
+				t.Fatal(err)
+			}
+			queue := workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond))
+			defer queue.ShutDown()
+			var obj interface{} = old
+			if tt.tombstone {
+				obj = cache.DeletedFinalStateUnknown{Key: "default/rule", Obj: old}
+			}
+			c.enqueueDeleted(queue, obj)
+
+			if err := c.syncHandler(ctx, "default/rule"); err != nil {
+				t.Fatalf("syncHandler() error = %v", err)
+			}
+			if _, ok := c.unselected.Load("default/rule"); ok {
+				t.Error("rule still marked as unselected after its sync")
+			}
+			if tt.current == nil {
+				return
+			}
+			rule, err := client.RulescontrollerV1alpha1().MimirRules("default").Get(ctx, "rule", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			released := !controllerutil.ContainsFinalizer(rule, v1alpha1.RuleFinalizer) && rule.Status.MimirNamespace == ""
+			if released != tt.wantReleased {
+				t.Errorf("released = %v, want %v", released, tt.wantReleased)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// shardOf returns the shard of a MimirRule key.
+func shardOf(key string, count int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(count))
+}
+
+// shards tracks the shards of MimirRule keys owned by this replica and the
+// rules being reconciled in each of them, so a shard is only handed over
+// once its in-flight rules are done.
+type shards struct {
+	count int
+
+	mu sync.Mutex
+	// owned maps an owned shard to the context of its term
+	owned map[int]context.Context
+	// inflight counts the rules being reconciled per shard
+	inflight map[int]int
+	idle     *sync.Cond
+}
+
+func newShards(count int) *shards {
+	s := &shards{
+		count:    count,
+		owned:    map[int]context.Context{},
+		inflight: map[int]int{},
+	}
+	s.idle = sync.NewCond(&s.mu)
+	return s
+}
+
+// owns reports whether the shard of key is owned by this replica.
+func (s *shards) owns(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.owned[shardOf(key, s.count)]
+	return ok
+}
+
+// begin marks key as being reconciled and returns its shard and the context
+// of the shard term, or false if the shard is not owned.
+func (s *shards) begin(key string) (int, context.Context, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	shard := shardOf(key, s.count)
+	term, ok := s.owned[shard]
+	if !ok {
+		return 0, nil, false
+	}
+	s.inflight[shard]++
+	return shard, term, true
+}
+
+// end marks a reconcile started with begin as done.
+func (s *shards) end(shard int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inflight[shard]--
+	s.idle.Broadcast()
+}
+
+// acquire marks shard as owned for the term carried by ctx.
+func (s *shards) acquire(ctx context.Context, shard int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.owned[shard] = ctx
+}
+
+// release stops accepting rules of shard and waits until its in-flight
+// rules are done or ctx is cancelled.
+func (s *shards) release(ctx context.Context, shard int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.owned, shard)
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.idle.Broadcast()
+	})
+	defer stop()
+	for s.inflight[shard] > 0 && ctx.Err() == nil {
+		s.idle.Wait()
+	}
+}
+
+// len returns the number of owned shards.
+func (s *shards) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.owned)
+}
+
+// surplus reports whether shard should be handed over because this replica
+// owns more than quota shards. Only the highest owned shard is handed over
+// at a time.
+func (s *shards) surplus(shard, quota int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.owned) <= quota {
+		return false
+	}
+	for owned := range s.owned {
+		if owned > shard {
+			return false
+		}
+	}
+	return true
+}
+
+// quota returns the number of shards a replica should own when there are
+// members replicas.
+func (s *shards) quota(members int) int {
+	if members < 1 {
+		members = 1
+	}
+	return (s.count + members - 1) / members
+}
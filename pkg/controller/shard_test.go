@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestShardOf(t *testing.T) {
+	for _, count := range []int{1, 2, 3, 16} {
+		seen := map[int]bool{}
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("namespace-%d/rule", i)
+			shard := shardOf(key, count)
+			if shard < 0 || shard >= count {
+				t.Fatalf("shardOf(%q, %d) = %d, out of range", key, count, shard)
+			}
+			if again := shardOf(key, count); again != shard {
+				t.Fatalf("shardOf(%q, %d) = %d then %d", key, count, shard, again)
+			}
+			seen[shard] = true
+		}
+		if len(seen) != count {
+			t.Errorf("1000 keys hashed to %d of %d shards", len(seen), count)
+		}
+	}
+	// Replicas running different versions must agree on the shard of a key
+	if got := shardOf("default/rule", 16); got != 13 {
+		t.Errorf("shardOf(default/rule, 16) = %d, want 13", got)
+	}
+}
+
+func TestShardsQuota(t *testing.T) {
+	tests := []struct {
+		count, members, want int
+	}{
+		{count: 1, members: 1, want: 1},
+		{count: 1, members: 3, want: 1},
+		{count: 4, members: 0, want: 4},
+		{count: 4, members: 1, want: 4},
+		{count: 4, members: 2, want: 2},
+		{count: 4, members: 3, want: 2},
+		{count: 16, members: 5, want: 4},
+		{count: 16, members: 16, want: 1},
+		{count: 16, members: 20, want: 1},
+	}
+	for _, tt := range tests {
+		if got := newShards(tt.count).quota(tt.members); got != tt.want {
+			t.Errorf("quota() of %d shards for %d members = %d, want %d", tt.count, tt.members, got, tt.want)
+		}
+	}
+}
+
+func TestShardsSurplus(t *testing.T) {
+	tests := []struct {
+		name  string
+		owned []int
+		shard int
+		quota int
+		want  bool
+	}{
+		{name: "within quota", owned: []int{0, 1}, shard: 1, quota: 2},
+		{name: "highest shard over quota", owned: []int{0, 1, 3}, shard: 3, quota: 2, want: true},
+		{name: "lower shard over quota", owned: []int{0, 1, 3}, shard: 1, quota: 2},
+		{name: "last shard", owned: []int{2}, shard: 2, quota: 0, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newShards(4)
+			for _, shard := range tt.owned {
+				s.acquire(context.Background(), shard)
+			}
+			if got := s.surplus(tt.shard, tt.quota); got != tt.want {
+				t.Errorf("surplus(%d, %d) = %v, want %v", tt.shard, tt.quota, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardsOwnership(t *testing.T) {
+	const key = "default/rule"
+	s := newShards(4)
+	shard := shardOf(key, 4)
+	if s.owns(key) {
+		t.Fatal("owns() before acquire")
+	}
+	if _, _, ok := s.begin(key); ok {
+		t.Fatal("begin() succeeded before acquire")
+	}
+
+	term, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.acquire(term, shard)
+	if !s.owns(key) || s.len() != 1 {
+		t.Fatalf("owns() = %v, len() = %d after acquire", s.owns(key), s.len())
+	}
+	got, ctx, ok := s.begin(key)
+	if !ok || got != shard || ctx != term {
+		t.Fatalf("begin() = %d, %v, %v, want %d and the term", got, ctx, ok, shard)
+	}
+
+	// release waits for the in-flight rule
+	released := make(chan struct{})
+	go func() {
+		s.release(context.Background(), shard)
+		close(released)
+	}()
+	select {
+	case <-released:
+		t.Fatal("release() returned with a rule in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if s.owns(key) {
+		t.Error("owns() while releasing")
+	}
+	s.end(shard)
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("release() did not return after end()")
+	}
+	if s.len() != 0 {
+		t.Errorf("len() = %d after release", s.len())
+	}
+}
+
+// TestShardsReleaseCancelled checks that release gives up waiting for the
+// in-flight rules when its context is cancelled.
+func TestShardsReleaseCancelled(t *testing.T) {
+	const key = "default/rule"
+	s := newShards(1)
+	s.acquire(context.Background(), 0)
+	if _, _, ok := s.begin(key); !ok {
+		t.Fatal("begin() failed")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	released := make(chan struct{})
+	go func() {
+		s.release(ctx, 0)
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("release() did not return after its context was cancelled")
+	}
+}
@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ruleSource feeds the events of the generated MimirRule informer, and of
+// the namespace informer when a namespace selector is set, to the work queue
+// of the controller-runtime controller.
+type ruleSource struct {
+	controller *Controller
+}
+
+var _ source.SyncingSource = &ruleSource{}
+
+// Start registers the event handlers on the informer. They are removed when
+// ctx is cancelled so the informer stops feeding a queue nobody drains.
+func (s *ruleSource) Start(ctx context.Context, queue workqueue.RateLimitingInterface) error {
+	registration, err := s.controller.ruleInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			s.controller.enqueueSelected(queue, obj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			s.controller.enqueueDeleted(queue, obj)
+			s.controller.enqueueConflicting(queue, obj)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			s.controller.enqueueSelected(queue, new)
+			s.controller.enqueueUnselected(queue, old, new)
+			s.controller.enqueueConflicting(queue, old, new)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	registrations := map[cache.SharedIndexInformer]cache.ResourceEventHandlerRegistration{
+		s.controller.ruleInformer: registration,
+	}
+
+	if s.controller.namespaceInformer != nil {
+		registration, err := s.controller.namespaceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			UpdateFunc: func(old, new interface{}) {
+				s.controller.enqueueNamespace(queue, old, new)
+			},
+		})
+		if err != nil {
+			return err
+		}
+		registrations[s.controller.namespaceInformer] = registration
+	}
+
+	s.controller.queueMu.Lock()
+	s.controller.queue = queue
+	s.controller.queueMu.Unlock()
+	s.controller.progress.Store(time.Now().UnixNano())
+
+	go func() {
+		<-ctx.Done()
+		for informer, registration := range registrations {
+			if err := informer.RemoveEventHandler(registration); err != nil {
+				klog.ErrorS(err, "Error removing event handler")
+			}
+		}
+	}()
+	return nil
+}
+
+// WaitForSync blocks until the informer cache has synced, so conflicts and
+// limits are never checked against a partial list of rules.
+func (s *ruleSource) WaitForSync(ctx context.Context) error {
+	klog.InfoS("Waiting for informer caches to sync")
+	synced := []cache.InformerSynced{s.controller.ruleInformer.HasSynced}
+	if s.controller.namespaceInformer != nil {
+		synced = append(synced, s.controller.namespaceInformer.HasSynced)
+	}
+	if ok := cache.WaitForCacheSync(ctx.Done(), synced...); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+	return nil
+}
+
+func (s *ruleSource) String() string {
+	return "MimirRule informer"
+}
@@ -1,5 +1,5 @@
 /*
-Copyright 2022 The mimir-rules-controller Authors.
+Copyright 2026 The mimir-rules-controller Authors.
 */
 
 // Code generated by client-gen. DO NOT EDIT.
@@ -21,8 +21,7 @@ type Interface interface {
 	RulescontrollerV1alpha1() rulescontrollerv1alpha1.RulescontrollerV1alpha1Interface
 }
 
-// Clientset contains the clients for groups. Each group has exactly one
-// version included in a Clientset.
+// Clientset contains the clients for groups.
 type Clientset struct {
 	*discovery.DiscoveryClient
 	rulescontrollerV1alpha1 *rulescontrollerv1alpha1.RulescontrollerV1alpha1Client
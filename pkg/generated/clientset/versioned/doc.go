@@ -1,8 +0,0 @@
-/*
-Copyright 2022 The mimir-rules-controller Authors.
-*/
-
-// Code generated by client-gen. DO NOT EDIT.
-
-// This package has the automatically generated clientset.
-package versioned
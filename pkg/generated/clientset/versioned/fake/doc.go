@@ -1,5 +1,5 @@
 /*
-Copyright 2022 The mimir-rules-controller Authors.
+Copyright 2026 The mimir-rules-controller Authors.
 */
 
 // Code generated by client-gen. DO NOT EDIT.
@@ -1,5 +1,5 @@
 /*
-Copyright 2022 The mimir-rules-controller Authors.
+Copyright 2026 The mimir-rules-controller Authors.
 */
 
 // Code generated by client-gen. DO NOT EDIT.
@@ -12,7 +12,6 @@ import (
 	v1alpha1 "github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	labels "k8s.io/apimachinery/pkg/labels"
-	schema "k8s.io/apimachinery/pkg/runtime/schema"
 	types "k8s.io/apimachinery/pkg/types"
 	watch "k8s.io/apimachinery/pkg/watch"
 	testing "k8s.io/client-go/testing"
@@ -24,9 +23,9 @@ type FakeMimirRules struct {
 	ns   string
 }
 
-var mimirrulesResource = schema.GroupVersionResource{Group: "rulescontroller.mimir.grafana.com", Version: "v1alpha1", Resource: "mimirrules"}
+var mimirrulesResource = v1alpha1.SchemeGroupVersion.WithResource("mimirrules")
 
-var mimirrulesKind = schema.GroupVersionKind{Group: "rulescontroller.mimir.grafana.com", Version: "v1alpha1", Kind: "MimirRule"}
+var mimirrulesKind = v1alpha1.SchemeGroupVersion.WithKind("MimirRule")
 
 // Get takes name of the mimirRule, and returns the corresponding mimirRule object, and an error if there is any.
 func (c *FakeMimirRules) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1alpha1.MimirRule, err error) {
@@ -1,5 +1,5 @@
 /*
-Copyright 2022 The mimir-rules-controller Authors.
+Copyright 2026 The mimir-rules-controller Authors.
 */
 
 // Code generated by client-gen. DO NOT EDIT.
@@ -19,7 +19,7 @@ type RulescontrollerV1alpha1Interface interface {
 	MimirRulesGetter
 }
 
-// RulescontrollerV1alpha1Client is used to interact with features provided by the rulescontroller.mimir.grafana.com group.
+// RulescontrollerV1alpha1Client is used to interact with features provided by the rulescontroller.k8s.healthjoy.com group.
 type RulescontrollerV1alpha1Client struct {
 	restClient rest.Interface
 }
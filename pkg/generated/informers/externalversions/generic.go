@@ -1,5 +1,5 @@
 /*
-Copyright 2022 The mimir-rules-controller Authors.
+Copyright 2026 The mimir-rules-controller Authors.
 */
 
 // Code generated by informer-gen. DO NOT EDIT.
@@ -40,7 +40,7 @@ func (f *genericInformer) Lister() cache.GenericLister {
 // TODO extend this to unknown resources with a client pool
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
-	// Group=rulescontroller.mimir.grafana.com, Version=v1alpha1
+	// Group=rulescontroller.k8s.healthjoy.com, Version=v1alpha1
 	case v1alpha1.SchemeGroupVersion.WithResource("mimirrules"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Rulescontroller().V1alpha1().MimirRules().Informer()}, nil
 
@@ -0,0 +1,112 @@
+// Package mimir extends the mimirtool client with the Mimir endpoints the
+// controller needs besides the ruler API.
+package mimir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/grafana/mimir/pkg/mimirtool/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/tracing"
+)
+
+const orgIDHeaderName = "X-Scope-OrgID"
+
+// Client is a Mimir API client.
+type Client struct {
+	*client.MimirClient
+
+	cfg       client.Config
+	endpoint  *url.URL
+	transport *transport
+}
+
+// New returns a new Mimir client for the given configuration. All the
+// requests, including the ones of the ruler API, go through the rate limiter
+// and circuit breaker configured by options and are traced.
+func New(cfg client.Config, options Options) (*Client, error) {
+	mimirClient, err := client.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := url.Parse(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	transport := newTransport(mimirClient.Client.Transport, options)
+	// Trace the requests around the rate limiter and the circuit breaker so
+	// the spans show the time spent waiting and the rejected requests
+	mimirClient.Client.Transport = otelhttp.NewTransport(transport,
+		otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+			return "Mimir " + req.Method + " " + req.URL.Path
+		}),
+		otelhttp.WithSpanOptions(trace.WithAttributes(tracing.Tenant.String(cfg.ID))),
+	)
+	return &Client{MimirClient: mimirClient, cfg: cfg, endpoint: endpoint, transport: transport}, nil
+}
+
+// Collectors returns the metrics of the client.
+func (c *Client) Collectors() []prometheus.Collector {
+	return c.transport.collectors()
+}
+
+// TenantID returns the tenant the client acts on behalf of.
+func (c *Client) TenantID() string {
+	return c.cfg.ID
+}
+
+// get sends a GET request for the given escaped path and returns the
+// response body, or client.ErrResourceNotFound on a 404. Authentication
+// mirrors the one of the mimirtool client.
+func (c *Client) get(ctx context.Context, path string) ([]byte, error) {
+	endpoint := *c.endpoint
+	endpoint.RawPath = strings.TrimSuffix(endpoint.EscapedPath(), "/") + path
+	unescaped, err := url.PathUnescape(endpoint.RawPath)
+	if err != nil {
+		return nil, err
+	}
+	endpoint.Path = unescaped
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case (c.cfg.User != "" || c.cfg.Key != "") && c.cfg.AuthToken != "":
+		return nil, errors.New("at most one of basic auth or auth token should be configured")
+	case c.cfg.User != "":
+		req.SetBasicAuth(c.cfg.User, c.cfg.Key)
+	case c.cfg.Key != "":
+		req.SetBasicAuth(c.cfg.ID, c.cfg.Key)
+	case c.cfg.AuthToken != "":
+		req.Header.Add("Authorization", "Bearer "+c.cfg.AuthToken)
+	}
+	req.Header.Add(orgIDHeaderName, c.cfg.ID)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, client.ErrResourceNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("GET request to %s failed: server returned HTTP status: %s", req.URL.String(), resp.Status)
+	}
+	return body, nil
+}
@@ -0,0 +1,29 @@
+package mimir
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const userLimitsPath = "/api/v1/user_limits"
+
+// UserLimits are the ruler limits of a tenant, as returned by the Mimir user
+// limits API. A zero value means the limit is disabled.
+type UserLimits struct {
+	RulerMaxRulesPerRuleGroup   int `json:"ruler_max_rules_per_rule_group"`
+	RulerMaxRuleGroupsPerTenant int `json:"ruler_max_rule_groups_per_tenant"`
+}
+
+// UserLimits fetches the limits of the tenant of the client.
+func (c *Client) UserLimits(ctx context.Context) (*UserLimits, error) {
+	body, err := c.get(ctx, userLimitsPath)
+	if err != nil {
+		return nil, err
+	}
+	limits := &UserLimits{}
+	if err := json.Unmarshal(body, limits); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal user limits: %w", err)
+	}
+	return limits, nil
+}
@@ -0,0 +1,21 @@
+package mimir
+
+import (
+	"context"
+	"errors"
+
+	"github.com/grafana/mimir/pkg/mimirtool/client"
+)
+
+// probeNamespace is the ruler namespace listed to check the ruler API. It is
+// not expected to exist: a not found answer still proves the address and the
+// credentials are right.
+const probeNamespace = "mimir-rules-controller-probe"
+
+// Ping checks that the ruler API of the tenant can be reached.
+func (c *Client) Ping(ctx context.Context) error {
+	if _, err := c.ListRules(ctx, probeNamespace); err != nil && !errors.Is(err, client.ErrResourceNotFound) {
+		return err
+	}
+	return nil
+}
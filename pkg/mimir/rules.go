@@ -0,0 +1,35 @@
+package mimir
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/grafana/mimir/pkg/mimirtool/rules/rwrulefmt"
+	"gopkg.in/yaml.v3"
+)
+
+// Prefixes of the ruler API, as used by the mimirtool client.
+const (
+	rulerAPIPath       = "/prometheus/config/v1/rules"
+	legacyRulerAPIPath = "/api/v1/rules"
+)
+
+// GetRuleGroup returns the rule group of the Mimir namespace, or
+// client.ErrResourceNotFound when it does not exist. It replaces the method of
+// the mimirtool client, which prints the request path to stdout.
+func (c *Client) GetRuleGroup(ctx context.Context, namespace, groupName string) (*rwrulefmt.RuleGroup, error) {
+	path := rulerAPIPath
+	if c.cfg.UseLegacyRoutes {
+		path = legacyRulerAPIPath
+	}
+	body, err := c.get(ctx, path+"/"+url.PathEscape(namespace)+"/"+url.PathEscape(groupName))
+	if err != nil {
+		return nil, err
+	}
+	group := &rwrulefmt.RuleGroup{}
+	if err := yaml.Unmarshal(body, group); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal rule group: %w", err)
+	}
+	return group, nil
+}
@@ -0,0 +1,391 @@
+package mimir
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+)
+
+// Options tune how the client protects Mimir from the controller.
+type Options struct {
+	// QPS is the rate of requests sent to Mimir. Zero disables the limit.
+	QPS float64
+	// Burst is the burst of requests sent to Mimir.
+	Burst int
+	// BreakerFailures is the number of consecutive failed requests that
+	// opens the circuit breaker. Zero disables the breaker.
+	BreakerFailures int
+	// BreakerOpenDuration is how long the circuit breaker stays open before
+	// letting a request through to probe Mimir.
+	BreakerOpenDuration time.Duration
+	// MaxConcurrentRequests is the number of requests in flight to Mimir at
+	// once, across all the workers. Zero disables the limit.
+	MaxConcurrentRequests int
+}
+
+// Defaults of Options.
+const (
+	DefaultQPS                   = 20
+	DefaultBurst                 = 40
+	DefaultBreakerFailures       = 5
+	DefaultBreakerOpenDuration   = 30 * time.Second
+	DefaultMaxConcurrentRequests = 16
+)
+
+// Circuit breaker states, exported as the value of the state metric.
+const (
+	stateClosed = iota
+	stateHalfOpen
+	stateOpen
+)
+
+var stateNames = []string{"closed", "half-open", "open"}
+
+// minRetryAfter is the delay of throttled requests without Retry-After.
+const minRetryAfter = time.Second
+
+// RetryAfterError is returned when a request was not sent, or was rejected
+// by Mimir, and should be retried after Delay.
+type RetryAfterError struct {
+	Delay  time.Duration
+	Reason string
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Reason, e.Delay)
+}
+
+// StatusError is returned when Mimir rejected a request with a client error
+// that sending it again cannot fix.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("Mimir rejected the request: %s", e.Status)
+	}
+	return fmt.Sprintf("Mimir rejected the request: %s: %s", e.Status, e.Body)
+}
+
+// IsPermanent reports whether err is a rejection by Mimir that retrying the
+// same request cannot fix, such as an invalid rule group.
+func IsPermanent(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr)
+}
+
+// permanent reports whether a response status rejects the request for good.
+// Not found and conflict are left to the mimirtool client, which maps them
+// to its own errors, and authentication errors are fixed by configuration
+// rather than by the rules.
+func permanent(code int) bool {
+	switch code {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// maxErrorBody is the size of the response body kept in a StatusError.
+const maxErrorBody = 1024
+
+// transport rate limits the requests sent to Mimir, honors the Retry-After
+// header of throttled responses and fails fast while Mimir keeps failing.
+type transport struct {
+	next    http.RoundTripper
+	options Options
+	limiter *rate.Limiter
+	// slots holds a token per request in flight, nil without a limit
+	slots chan struct{}
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	// until is the end of the Retry-After delay or of the open state
+	until time.Time
+	// probing is set while the request probing a half-open breaker is sent
+	probing bool
+
+	stateGauge      prometheus.Gauge
+	inflightGauge   prometheus.Gauge
+	throttledTotal  prometheus.Counter
+	rejectedTotal   prometheus.Counter
+	transitionTotal *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+}
+
+func newTransport(next http.RoundTripper, options Options) *transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	limit := rate.Inf
+	if options.QPS > 0 {
+		limit = rate.Limit(options.QPS)
+	}
+	if options.Burst < 1 {
+		options.Burst = 1
+	}
+	var slots chan struct{}
+	if options.MaxConcurrentRequests > 0 {
+		slots = make(chan struct{}, options.MaxConcurrentRequests)
+	}
+	return &transport{
+		next:    next,
+		options: options,
+		limiter: rate.NewLimiter(limit, options.Burst),
+		slots:   slots,
+
+		stateGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mimir_rules_controller_mimir_circuit_breaker_state",
+			Help: "State of the circuit breaker of the Mimir client: 0 closed, 1 half-open, 2 open",
+		}),
+		inflightGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "mimir_rules_controller_mimir_inflight_requests",
+			Help: "Number of requests in flight to Mimir",
+		}),
+		throttledTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mimir_rules_controller_mimir_throttled_requests_total",
+			Help: "Total number of requests throttled by Mimir",
+		}),
+		rejectedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "mimir_rules_controller_mimir_rejected_requests_total",
+			Help: "Total number of requests not sent to Mimir because the circuit breaker is open or Mimir asked to retry later",
+		}),
+		transitionTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mimir_rules_controller_mimir_circuit_breaker_transitions_total",
+			Help: "Total number of circuit breaker transitions of the Mimir client by new state",
+		}, []string{"state"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "mimir_rules_controller_mimir_request_duration_seconds",
+			Help:    "Duration of the requests sent to Mimir by operation and status code",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"operation", "code"}),
+	}
+}
+
+// collectors returns the metrics of the transport.
+func (t *transport) collectors() []prometheus.Collector {
+	return []prometheus.Collector{t.stateGauge, t.inflightGauge, t.throttledTotal, t.rejectedTotal, t.transitionTotal, t.requestDuration}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.admit(); err != nil {
+		t.rejectedTotal.Inc()
+		return nil, err
+	}
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		t.abort()
+		return nil, err
+	}
+	if err := t.acquire(req); err != nil {
+		t.abort()
+		return nil, err
+	}
+	defer t.release()
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	t.requestDuration.WithLabelValues(operation(req), code).Observe(time.Since(start).Seconds())
+	if err != nil {
+		if req.Context().Err() != nil {
+			// Cancelled by the caller, Mimir is not to blame
+			t.abort()
+		} else {
+			t.done(false, 0)
+		}
+		return nil, err
+	}
+
+	if permanent(resp.StatusCode) {
+		// Mimir is up, the request is at fault
+		t.done(true, 0)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		resp.Body.Close()
+		return nil, &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(body))}
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		t.done(true, 0)
+		return resp, nil
+	}
+
+	delay := retryAfter(resp)
+	t.done(false, delay)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		t.throttledTotal.Inc()
+		if delay < minRetryAfter {
+			delay = minRetryAfter
+		}
+	}
+	if delay <= 0 {
+		return resp, nil
+	}
+	// Surface the delay to the caller, the mimirtool client would turn the
+	// response into a plain error
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, &RetryAfterError{Delay: delay, Reason: fmt.Sprintf("Mimir answered %s", resp.Status)}
+}
+
+// acquire waits for a slot to send req within the concurrency limit.
+func (t *transport) acquire(req *http.Request) error {
+	if t.slots != nil {
+		select {
+		case t.slots <- struct{}{}:
+		case <-req.Context().Done():
+			return req.Context().Err()
+		}
+	}
+	t.inflightGauge.Inc()
+	return nil
+}
+
+// release frees the slot of a sent request.
+func (t *transport) release() {
+	t.inflightGauge.Dec()
+	if t.slots != nil {
+		<-t.slots
+	}
+}
+
+// admit returns an error when the request must not be sent.
+func (t *transport) admit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	switch t.state {
+	case stateOpen:
+		if now.Before(t.until) {
+			return &RetryAfterError{Delay: t.until.Sub(now), Reason: "circuit breaker of the Mimir client is open"}
+		}
+		t.setState(stateHalfOpen)
+		t.probing = true
+		return nil
+	case stateHalfOpen:
+		if t.probing {
+			return &RetryAfterError{Delay: t.options.BreakerOpenDuration, Reason: "circuit breaker of the Mimir client is half-open"}
+		}
+		t.probing = true
+		return nil
+	}
+	if now.Before(t.until) {
+		return &RetryAfterError{Delay: t.until.Sub(now), Reason: "Mimir asked to retry later"}
+	}
+	return nil
+}
+
+// abort records that an admitted request was not sent.
+func (t *transport) abort() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.probing = false
+}
+
+// done records the outcome of a sent request.
+func (t *transport) done(success bool, retryAfter time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.probing = false
+	if retryAfter > 0 {
+		if until := time.Now().Add(retryAfter); until.After(t.until) {
+			t.until = until
+		}
+	}
+	if success {
+		t.failures = 0
+		if t.state != stateClosed {
+			t.setState(stateClosed)
+		}
+		return
+	}
+
+	t.failures++
+	if t.options.BreakerFailures > 0 && (t.state == stateHalfOpen || t.failures >= t.options.BreakerFailures) {
+		if until := time.Now().Add(t.options.BreakerOpenDuration); until.After(t.until) {
+			t.until = until
+		}
+		if t.state != stateOpen {
+			t.setState(stateOpen)
+		}
+	}
+}
+
+func (t *transport) setState(state int) {
+	if state == stateOpen {
+		klog.InfoS("Opening the circuit breaker of the Mimir client", "failures", t.failures)
+	} else {
+		klog.InfoS("Circuit breaker of the Mimir client changed state", "state", stateNames[state])
+	}
+	t.state = state
+	t.stateGauge.Set(float64(state))
+	t.transitionTotal.WithLabelValues(stateNames[state]).Inc()
+}
+
+// retryAfter parses the Retry-After header of resp, given either in seconds
+// or as an HTTP date.
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// rulesPaths are the prefixes of the ruler API, with and without the legacy
+// routes.
+var rulesPaths = []string{"/config/v1/rules", "/api/v1/rules"}
+
+// operation names the Mimir API operation of req for the metrics.
+func operation(req *http.Request) string {
+	path := req.URL.EscapedPath()
+	if strings.HasSuffix(path, userLimitsPath) {
+		return "user_limits"
+	}
+	for _, prefix := range rulesPaths {
+		i := strings.Index(path, prefix)
+		if i < 0 {
+			continue
+		}
+		// The rest of the path is the namespace and group, both escaped
+		segments := 0
+		if rest := strings.Trim(path[i+len(prefix):], "/"); rest != "" {
+			segments = strings.Count(rest, "/") + 1
+		}
+		switch {
+		case req.Method == http.MethodPost:
+			return "create_rule_group"
+		case req.Method == http.MethodDelete && segments == 2:
+			return "delete_rule_group"
+		case req.Method == http.MethodDelete:
+			return "delete_namespace"
+		case segments == 2:
+			return "get_rule_group"
+		default:
+			return "list_rules"
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,315 @@
+package mimir
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestBreaker(t *testing.T) {
+	type step struct {
+		// admit is checked when set, done is called otherwise
+		admit   bool
+		wantErr string
+		success bool
+		delay   time.Duration
+		// elapse moves the end of the current delay to the past
+		elapse    bool
+		wantState int
+	}
+	tests := []struct {
+		name     string
+		failures int
+		steps    []step
+	}{
+		{
+			name:     "closed",
+			failures: 2,
+			steps: []step{
+				{admit: true, wantState: stateClosed},
+				{success: false, wantState: stateClosed},
+				{admit: true, wantState: stateClosed},
+				{success: true, wantState: stateClosed},
+				{success: false, wantState: stateClosed},
+				{admit: true, wantState: stateClosed},
+			},
+		},
+		{
+			name:     "opens after consecutive failures",
+			failures: 2,
+			steps: []step{
+				{success: false, wantState: stateClosed},
+				{success: false, wantState: stateOpen},
+				{admit: true, wantErr: "circuit breaker of the Mimir client is open", wantState: stateOpen},
+			},
+		},
+		{
+			name:     "probe succeeds",
+			failures: 1,
+			steps: []step{
+				{success: false, wantState: stateOpen},
+				{elapse: true, admit: true, wantState: stateHalfOpen},
+				{admit: true, wantErr: "circuit breaker of the Mimir client is half-open", wantState: stateHalfOpen},
+				{success: true, wantState: stateClosed},
+				{admit: true, wantState: stateClosed},
+			},
+		},
+		{
+			name:     "probe fails",
+			failures: 3,
+			steps: []step{
+				{success: false},
+				{success: false},
+				{success: false, wantState: stateOpen},
+				{elapse: true, admit: true, wantState: stateHalfOpen},
+				{success: false, wantState: stateOpen},
+				{admit: true, wantErr: "circuit breaker of the Mimir client is open", wantState: stateOpen},
+			},
+		},
+		{
+			name:     "disabled breaker",
+			failures: 0,
+			steps: []step{
+				{success: false},
+				{success: false},
+				{success: false},
+				{admit: true, wantState: stateClosed},
+			},
+		},
+		{
+			name:     "retry after",
+			failures: 0,
+			steps: []step{
+				{success: false, delay: time.Minute, wantState: stateClosed},
+				{admit: true, wantErr: "Mimir asked to retry later", wantState: stateClosed},
+				{elapse: true, admit: true, wantState: stateClosed},
+			},
+		},
+		{
+			name:     "retry after a success",
+			failures: 2,
+			steps: []step{
+				{success: true, delay: time.Minute, wantState: stateClosed},
+				{admit: true, wantErr: "Mimir asked to retry later", wantState: stateClosed},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTransport(nil, Options{BreakerFailures: tt.failures, BreakerOpenDuration: time.Minute})
+			for i, s := range tt.steps {
+				if s.elapse {
+					tr.until = time.Now().Add(-time.Second)
+				}
+				if s.admit {
+					err := tr.admit()
+					var retryErr *RetryAfterError
+					switch {
+					case s.wantErr == "" && err != nil:
+						t.Fatalf("step %d: admit() error = %v", i, err)
+					case s.wantErr != "" && !errors.As(err, &retryErr):
+						t.Fatalf("step %d: admit() error = %v, want a RetryAfterError", i, err)
+					case s.wantErr != "" && (retryErr.Reason != s.wantErr || retryErr.Delay <= 0):
+						t.Fatalf("step %d: admit() error = %+v, want %q with a delay", i, retryErr, s.wantErr)
+					}
+				} else {
+					tr.done(s.success, s.delay)
+				}
+				if tr.state != s.wantState {
+					t.Fatalf("step %d: state = %s, want %s", i, stateNames[tr.state], stateNames[s.wantState])
+				}
+			}
+		})
+	}
+}
+
+// TestBreakerAbort checks that a probe not sent lets the next request probe.
+func TestBreakerAbort(t *testing.T) {
+	tr := newTransport(nil, Options{BreakerFailures: 1, BreakerOpenDuration: time.Minute})
+	tr.done(false, 0)
+	tr.until = time.Now().Add(-time.Second)
+	if err := tr.admit(); err != nil {
+		t.Fatalf("admit() error = %v", err)
+	}
+	tr.abort()
+	if err := tr.admit(); err != nil {
+		t.Fatalf("admit() after abort() error = %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		// the delay computed from a date is only known within a second
+		min, max time.Duration
+	}{
+		{name: "none"},
+		{name: "seconds", value: "5", min: 5 * time.Second, max: 5 * time.Second},
+		{name: "zero", value: "0"},
+		{name: "negative", value: "-3"},
+		{name: "invalid", value: "soon"},
+		{name: "date", value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), min: 58 * time.Second, max: time.Minute},
+		{name: "past date", value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), min: -2 * time.Minute, max: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.value != "" {
+				resp.Header.Set("Retry-After", tt.value)
+			}
+			if got := retryAfter(resp); got < tt.min || got > tt.max {
+				t.Errorf("retryAfter(%q) = %s, want within [%s, %s]", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestOperation(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: http.MethodGet, path: "/prometheus/config/v1/rules", want: "list_rules"},
+		{method: http.MethodGet, path: "/prometheus/config/v1/rules/ns", want: "list_rules"},
+		{method: http.MethodGet, path: "/prometheus/config/v1/rules/ns/group", want: "get_rule_group"},
+		{method: http.MethodGet, path: "/prometheus/config/v1/rules/ns%2Fa/group%2Fb", want: "get_rule_group"},
+		{method: http.MethodPost, path: "/prometheus/config/v1/rules/ns", want: "create_rule_group"},
+		{method: http.MethodDelete, path: "/prometheus/config/v1/rules/ns/group", want: "delete_rule_group"},
+		{method: http.MethodDelete, path: "/prometheus/config/v1/rules/ns", want: "delete_namespace"},
+		{method: http.MethodGet, path: "/api/v1/rules/ns/group", want: "get_rule_group"},
+		{method: http.MethodPost, path: "/api/v1/rules/ns", want: "create_rule_group"},
+		{method: http.MethodGet, path: "/api/v1/user_limits", want: "user_limits"},
+		{method: http.MethodGet, path: "/ready", want: "other"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://mimir"+tt.path, nil)
+		if got := operation(req); got != tt.want {
+			t.Errorf("operation(%s %s) = %q, want %q", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       int
+		retryAfter string
+		wantStatus bool
+		wantDelay  time.Duration
+		wantCode   int
+		wantState  int
+	}{
+		{name: "success", code: http.StatusOK, wantCode: http.StatusOK},
+		{name: "not found", code: http.StatusNotFound, wantCode: http.StatusNotFound},
+		{name: "bad request", code: http.StatusBadRequest, wantStatus: true},
+		{name: "too large", code: http.StatusRequestEntityTooLarge, wantStatus: true},
+		{name: "unprocessable", code: http.StatusUnprocessableEntity, wantStatus: true},
+		{name: "throttled", code: http.StatusTooManyRequests, wantDelay: minRetryAfter, wantState: stateOpen},
+		{name: "throttled with delay", code: http.StatusTooManyRequests, retryAfter: "7", wantDelay: 7 * time.Second, wantState: stateOpen},
+		{name: "internal error", code: http.StatusInternalServerError, wantCode: http.StatusInternalServerError, wantState: stateOpen},
+		{name: "unavailable", code: http.StatusServiceUnavailable, wantCode: http.StatusServiceUnavailable, wantState: stateOpen},
+		{name: "unavailable with delay", code: http.StatusServiceUnavailable, retryAfter: "3", wantDelay: 3 * time.Second, wantState: stateOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				resp := &http.Response{StatusCode: tt.code, Status: http.StatusText(tt.code), Header: http.Header{}, Body: io.NopCloser(strings.NewReader("body"))}
+				if tt.retryAfter != "" {
+					resp.Header.Set("Retry-After", tt.retryAfter)
+				}
+				return resp, nil
+			}), Options{BreakerFailures: 1, BreakerOpenDuration: time.Minute})
+
+			resp, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, "http://mimir/prometheus/config/v1/rules", nil))
+			var statusErr *StatusError
+			var retryErr *RetryAfterError
+			switch {
+			case tt.wantStatus:
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.code || !IsPermanent(err) {
+					t.Fatalf("RoundTrip() error = %v, want a permanent StatusError", err)
+				}
+			case tt.wantDelay > 0:
+				if !errors.As(err, &retryErr) || retryErr.Delay != tt.wantDelay || IsPermanent(err) {
+					t.Fatalf("RoundTrip() error = %v, want a RetryAfterError of %s", err, tt.wantDelay)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("RoundTrip() error = %v", err)
+				}
+				if resp.StatusCode != tt.wantCode {
+					t.Fatalf("RoundTrip() status = %d, want %d", resp.StatusCode, tt.wantCode)
+				}
+			}
+			if tr.state != tt.wantState {
+				t.Errorf("state = %s, want %s", stateNames[tr.state], stateNames[tt.wantState])
+			}
+		})
+	}
+}
+
+// TestRoundTripConcurrency checks that no more than MaxConcurrentRequests are
+// in flight and that a request cancelled while waiting frees nothing.
+func TestRoundTripConcurrency(t *testing.T) {
+	const limit = 2
+	var inflight, peak atomic.Int32
+	unblock := make(chan struct{})
+	tr := newTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		n := inflight.Add(1)
+		defer inflight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-unblock
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+	}), Options{MaxConcurrentRequests: limit})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := tr.RoundTrip(httptest.NewRequest(http.MethodGet, "http://mimir/", nil)); err != nil {
+				t.Errorf("RoundTrip() error = %v", err)
+			}
+		}()
+	}
+	for len(tr.slots) < limit {
+		time.Sleep(time.Millisecond)
+	}
+
+	// All slots are taken, a cancelled request gives up waiting
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "http://mimir/", nil).WithContext(ctx)
+	if _, err := tr.RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RoundTrip() error = %v, want a deadline", err)
+	}
+
+	close(unblock)
+	wg.Wait()
+	if got := peak.Load(); got > limit {
+		t.Errorf("%d requests in flight, want at most %d", got, limit)
+	}
+	if len(tr.slots) != 0 {
+		t.Errorf("%d slots still taken", len(tr.slots))
+	}
+}
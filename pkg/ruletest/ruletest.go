@@ -0,0 +1,437 @@
+// Package ruletest runs the unit tests of a MimirRule against its rendered
+// rule groups with the Prometheus rule engine, in the same way as promtool
+// rule unit tests.
+package ruletest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/mimir/pkg/mimirtool/rules"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	promrules "github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+const defaultInterval = time.Minute
+
+// Error is a failure of a single unit test.
+type Error struct {
+	// Test is the name of the test, or its index if unnamed.
+	Test string
+	Err  error
+}
+
+// Error prints the error message prefixed with the test name.
+func (err *Error) Error() string {
+	return fmt.Sprintf("test %s: %v", err.Test, err.Err)
+}
+
+// Unwrap unpacks wrapped error for use in errors.Is & errors.As.
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
+// Limits bound the resources used by the unit tests of a MimirRule, which
+// any author of a MimirRule can write. Zero disables a limit.
+type Limits struct {
+	// MaxSeries is the maximum number of input series of a test.
+	MaxSeries int
+	// MaxSamplesPerSeries is the maximum number of samples of an input
+	// series once expanded, and of evaluation intervals up to the last
+	// eval_time of a test.
+	MaxSamplesPerSeries int
+	// MaxEvalTime is the latest eval_time of a test.
+	MaxEvalTime time.Duration
+	// Timeout bounds the run of all the tests.
+	Timeout time.Duration
+}
+
+// DefaultLimits are the limits of the unit tests run by the controller.
+var DefaultLimits = Limits{
+	MaxSeries:           1000,
+	MaxSamplesPerSeries: 10000,
+	MaxEvalTime:         7 * 24 * time.Hour,
+	Timeout:             10 * time.Second,
+}
+
+// Run runs tests against the rule groups of ns within limits and returns the
+// failures. Exceeding a limit fails the test.
+func Run(ctx context.Context, ns *rules.RuleNamespace, tests []v1alpha1.RuleTest, limits Limits) []error {
+	if limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, limits.Timeout)
+		defer cancel()
+	}
+	var errs []error
+	for i, test := range tests {
+		name := test.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, &Error{Test: name, Err: fmt.Errorf("not run: %w", err)})
+			continue
+		}
+		for _, err := range runTest(ctx, ns, test, limits) {
+			errs = append(errs, &Error{Test: name, Err: err})
+		}
+	}
+	return errs
+}
+
+func runTest(ctx context.Context, ns *rules.RuleNamespace, test v1alpha1.RuleTest, limits Limits) (errs []error) {
+	// The Prometheus test storage reports failures through testingT
+	defer func() {
+		if r := recover(); r != nil {
+			errs = append(errs, fmt.Errorf("%v", r))
+		}
+	}()
+
+	interval := defaultInterval
+	if test.Interval != "" {
+		d, err := model.ParseDuration(string(test.Interval))
+		if err != nil {
+			return []error{fmt.Errorf("invalid interval: %w", err)}
+		}
+		interval = time.Duration(d)
+	}
+
+	alertTests := map[time.Duration][]v1alpha1.AlertRuleTest{}
+	var maxEvalTime time.Duration
+	for _, alert := range test.AlertRuleTests {
+		if alert.Alertname == "" {
+			return []error{fmt.Errorf("an item under alert_rule_test misses required attribute alertname at eval_time %s", alert.EvalTime)}
+		}
+		evalTime, err := model.ParseDuration(string(alert.EvalTime))
+		if err != nil {
+			return []error{fmt.Errorf("invalid eval_time of alert %q: %w", alert.Alertname, err)}
+		}
+		alertTests[time.Duration(evalTime)] = append(alertTests[time.Duration(evalTime)], alert)
+		if time.Duration(evalTime) > maxEvalTime {
+			maxEvalTime = time.Duration(evalTime)
+		}
+	}
+	exprEvalTimes := make([]time.Duration, len(test.PromQLExprTests))
+	for i, expr := range test.PromQLExprTests {
+		evalTime, err := model.ParseDuration(string(expr.EvalTime))
+		if err != nil {
+			return []error{fmt.Errorf("invalid eval_time of expr %q: %w", expr.Expr, err)}
+		}
+		exprEvalTimes[i] = time.Duration(evalTime)
+		if time.Duration(evalTime) > maxEvalTime {
+			maxEvalTime = time.Duration(evalTime)
+		}
+	}
+	if err := checkLimits(test, interval, maxEvalTime, limits); err != nil {
+		return []error{err}
+	}
+	alertEvalTimes := make([]time.Duration, 0, len(alertTests))
+	for t := range alertTests {
+		alertEvalTimes = append(alertEvalTimes, t)
+	}
+	sort.Slice(alertEvalTimes, func(i, j int) bool { return alertEvalTimes[i] < alertEvalTimes[j] })
+
+	suite, err := promql.NewLazyLoader(testingT{}, seriesLoadingString(interval, test.InputSeries), promql.LazyLoaderOpts{
+		EnableAtModifier:     true,
+		EnableNegativeOffset: true,
+	})
+	if err != nil {
+		return []error{err}
+	}
+	defer suite.Close()
+
+	groups, err := buildGroups(ctx, suite, ns, test, interval)
+	if err != nil {
+		return []error{err}
+	}
+
+	mint := time.Unix(0, 0).UTC()
+	maxt := mint.Add(maxEvalTime)
+	curr := 0
+	for ts := mint; !ts.After(maxt); ts = ts.Add(interval) {
+		if err := ctx.Err(); err != nil {
+			return append(errs, fmt.Errorf("evaluation aborted at %s: %w", ts.Sub(mint), err))
+		}
+		var evalErrs []error
+		suite.WithSamplesTill(ts, func(err error) {
+			if err != nil {
+				evalErrs = append(evalErrs, err)
+				return
+			}
+			for _, g := range groups {
+				g.Eval(ctx, ts)
+				for _, r := range g.Rules() {
+					if r.LastError() != nil {
+						evalErrs = append(evalErrs, fmt.Errorf("rule: %s, time: %s, err: %w", r.Name(), ts.Sub(mint), r.LastError()))
+					}
+				}
+			}
+		})
+		if len(evalErrs) > 0 {
+			return append(errs, evalErrs...)
+		}
+
+		// Alerts tested at eval_time are compared with the evaluation at ts
+		// when ts <= eval_time < ts+interval.
+		for curr < len(alertEvalTimes) && ts.Sub(mint) <= alertEvalTimes[curr] && alertEvalTimes[curr] < ts.Add(interval).Sub(mint) {
+			errs = append(errs, checkAlerts(groups, alertTests[alertEvalTimes[curr]])...)
+			curr++
+		}
+	}
+
+	for i, expr := range test.PromQLExprTests {
+		if err := checkExpr(ctx, suite, expr, mint.Add(exprEvalTimes[i])); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// checkLimits checks the input series and the eval_times of test before the
+// series are expanded and evaluated.
+func checkLimits(test v1alpha1.RuleTest, interval, maxEvalTime time.Duration, limits Limits) error {
+	if limits.MaxSeries > 0 && len(test.InputSeries) > limits.MaxSeries {
+		return fmt.Errorf("%d input series exceed the limit of %d", len(test.InputSeries), limits.MaxSeries)
+	}
+	if limits.MaxSamplesPerSeries > 0 {
+		for _, is := range test.InputSeries {
+			if n := countSamples(is.Values); n > uint64(limits.MaxSamplesPerSeries) {
+				return fmt.Errorf("input series %s: %d samples exceed the limit of %d", is.Series, n, limits.MaxSamplesPerSeries)
+			}
+		}
+		if steps := maxEvalTime / interval; steps > time.Duration(limits.MaxSamplesPerSeries) {
+			return fmt.Errorf("eval_time %s is %d intervals of %s, more than the limit of %d", model.Duration(maxEvalTime), steps, model.Duration(interval), limits.MaxSamplesPerSeries)
+		}
+	}
+	if limits.MaxEvalTime > 0 && maxEvalTime > limits.MaxEvalTime {
+		return fmt.Errorf("eval_time %s exceeds the limit of %s", model.Duration(maxEvalTime), model.Duration(limits.MaxEvalTime))
+	}
+	return nil
+}
+
+// countSamples returns the number of samples of the values of an input
+// series in the expanding notation, e.g. '1+2x3' has 4 samples and '_x3' 3,
+// without expanding them. Invalid values are left to the parser.
+func countSamples(values string) uint64 {
+	var total uint64
+	for _, item := range strings.Fields(values) {
+		n := uint64(1)
+		if i := strings.LastIndexByte(item, 'x'); i > 0 {
+			if times, err := strconv.ParseUint(item[i+1:], 10, 64); err == nil {
+				n = times
+				if item[:i] != "_" && times < math.MaxUint64 {
+					n++
+				}
+			}
+		}
+		if total+n < total {
+			return math.MaxUint64
+		}
+		total += n
+	}
+	return total
+}
+
+// buildGroups converts the rule groups of ns into Prometheus rule groups
+// reading from and writing to the test storage.
+func buildGroups(ctx context.Context, suite *promql.LazyLoader, ns *rules.RuleNamespace, test v1alpha1.RuleTest, interval time.Duration) ([]*promrules.Group, error) {
+	externalURL, err := url.Parse(test.ExternalURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid external_url: %w", err)
+	}
+	opts := &promrules.ManagerOptions{
+		QueryFunc:  promrules.EngineQueryFunc(suite.QueryEngine(), suite.Storage()),
+		Appendable: suite.Storage(),
+		Queryable:  suite.Storage(),
+		Context:    ctx,
+		NotifyFunc: func(context.Context, string, ...*promrules.Alert) {},
+		Logger:     log.NewNopLogger(),
+	}
+	externalLabels := labels.FromMap(test.ExternalLabels)
+
+	groups := make([]*promrules.Group, 0, len(ns.Groups))
+	for _, group := range ns.Groups {
+		groupRules := make([]promrules.Rule, 0, len(group.Rules))
+		for _, r := range group.Rules {
+			expr, err := parser.ParseExpr(r.Expr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("group %q: could not parse expression %q: %w", group.Name, r.Expr.Value, err)
+			}
+			if r.Alert.Value != "" {
+				groupRules = append(groupRules, promrules.NewAlertingRule(
+					r.Alert.Value, expr, time.Duration(r.For), time.Duration(r.KeepFiringFor),
+					labels.FromMap(r.Labels), labels.FromMap(r.Annotations), externalLabels, externalURL.String(),
+					// Restored so the ALERTS series is created when the rule runs
+					true, log.NewNopLogger(),
+				))
+				continue
+			}
+			groupRules = append(groupRules, promrules.NewRecordingRule(r.Record.Value, expr, labels.FromMap(r.Labels)))
+		}
+
+		groupInterval := interval
+		if group.Interval != 0 {
+			groupInterval = time.Duration(group.Interval)
+		}
+		groups = append(groups, promrules.NewGroup(promrules.GroupOptions{
+			Name:     group.Name,
+			File:     ns.Namespace,
+			Interval: groupInterval,
+			Limit:    group.Limit,
+			Rules:    groupRules,
+			Opts:     opts,
+		}))
+	}
+	return groups, nil
+}
+
+type labelsAndAnnotations struct {
+	Labels      labels.Labels
+	Annotations labels.Labels
+}
+
+func (la labelsAndAnnotations) String() string {
+	return "Labels:" + la.Labels.String() + " Annotations:" + la.Annotations.String()
+}
+
+func sortAlerts(alerts []labelsAndAnnotations) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if diff := labels.Compare(alerts[i].Labels, alerts[j].Labels); diff != 0 {
+			return diff < 0
+		}
+		return labels.Compare(alerts[i].Annotations, alerts[j].Annotations) < 0
+	})
+}
+
+// checkAlerts compares the firing alerts of the groups with the expected ones.
+func checkAlerts(groups []*promrules.Group, tests []v1alpha1.AlertRuleTest) []error {
+	var errs []error
+	for _, test := range tests {
+		var got []labelsAndAnnotations
+		// The same alert name can be present in multiple groups
+		for _, g := range groups {
+			for _, r := range g.Rules() {
+				ar, ok := r.(*promrules.AlertingRule)
+				if !ok || ar.Name() != test.Alertname {
+					continue
+				}
+				for _, a := range ar.ActiveAlerts() {
+					if a.State == promrules.StateFiring {
+						got = append(got, labelsAndAnnotations{Labels: a.Labels.Copy(), Annotations: a.Annotations.Copy()})
+					}
+				}
+			}
+		}
+
+		var exp []labelsAndAnnotations
+		for _, a := range test.ExpAlerts {
+			// The alertname label is added by the rule engine
+			lbls := labels.NewBuilder(labels.FromMap(a.ExpLabels)).Set(labels.AlertName, test.Alertname).Labels(nil)
+			exp = append(exp, labelsAndAnnotations{Labels: lbls, Annotations: labels.FromMap(a.ExpAnnotations)})
+		}
+
+		sortAlerts(got)
+		sortAlerts(exp)
+		if !reflect.DeepEqual(exp, got) {
+			errs = append(errs, fmt.Errorf("alertname: %s, time: %s, exp: %v, got: %v", test.Alertname, test.EvalTime, exp, got))
+		}
+	}
+	return errs
+}
+
+type parsedSample struct {
+	Labels labels.Labels
+	Value  float64
+}
+
+func (ps parsedSample) String() string {
+	return ps.Labels.String() + " " + fmt.Sprint(ps.Value)
+}
+
+// checkExpr compares the result of the expression at ts with the expected samples.
+func checkExpr(ctx context.Context, suite *promql.LazyLoader, test v1alpha1.PromQLExprTest, ts time.Time) error {
+	got, err := query(ctx, test.Expr, ts, suite.QueryEngine(), suite.Queryable())
+	if err != nil {
+		return fmt.Errorf("expr: %q, time: %s, err: %w", test.Expr, test.EvalTime, err)
+	}
+
+	gotSamples := make([]parsedSample, 0, len(got))
+	for _, s := range got {
+		gotSamples = append(gotSamples, parsedSample{Labels: s.Metric.Copy(), Value: s.V})
+	}
+	expSamples := make([]parsedSample, 0, len(test.ExpSamples))
+	for _, s := range test.ExpSamples {
+		lb, err := parser.ParseMetric(s.Labels)
+		if err != nil {
+			return fmt.Errorf("expr: %q, time: %s, err: labels %q: %w", test.Expr, test.EvalTime, s.Labels, err)
+		}
+		expSamples = append(expSamples, parsedSample{Labels: lb, Value: s.Value})
+	}
+
+	sort.Slice(expSamples, func(i, j int) bool { return labels.Compare(expSamples[i].Labels, expSamples[j].Labels) <= 0 })
+	sort.Slice(gotSamples, func(i, j int) bool { return labels.Compare(gotSamples[i].Labels, gotSamples[j].Labels) <= 0 })
+	if !reflect.DeepEqual(expSamples, gotSamples) {
+		return fmt.Errorf("expr: %q, time: %s, exp: %v, got: %v", test.Expr, test.EvalTime, expSamples, gotSamples)
+	}
+	return nil
+}
+
+func query(ctx context.Context, qs string, t time.Time, engine *promql.Engine, qu storage.Queryable) (promql.Vector, error) {
+	q, err := engine.NewInstantQuery(qu, nil, qs, t)
+	if err != nil {
+		return nil, err
+	}
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	switch v := res.Value.(type) {
+	case promql.Vector:
+		return v, nil
+	case promql.Scalar:
+		return promql.Vector{promql.Sample{
+			Point:  promql.Point{T: v.T, V: v.V},
+			Metric: labels.Labels{},
+		}}, nil
+	default:
+		return nil, errors.New("rule result is not a vector or scalar")
+	}
+}
+
+// seriesLoadingString returns the input series in the PromQL test notation.
+func seriesLoadingString(interval time.Duration, series []v1alpha1.InputSeries) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "load %s\n", model.Duration(interval))
+	for _, is := range series {
+		fmt.Fprintf(&sb, "  %s %s\n", is.Series, is.Values)
+	}
+	return sb.String()
+}
+
+// testingT satisfies testutil.T for the Prometheus test storage. Failures
+// abort the test with a panic that runTest recovers from.
+type testingT struct{}
+
+func (testingT) Errorf(format string, args ...interface{}) {
+	panic(fmt.Errorf(format, args...))
+}
+
+func (testingT) FailNow() {
+	panic(errors.New("test storage failure"))
+}
@@ -0,0 +1,146 @@
+package ruletest
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/healthjoy/mimir-rules-controller/pkg/apis/rulescontroller/v1alpha1"
+)
+
+func TestCountSamples(t *testing.T) {
+	tests := []struct {
+		values string
+		want   uint64
+	}{
+		{values: "", want: 0},
+		{values: "1 2 3", want: 3},
+		{values: "0+1x3", want: 4},
+		{values: "10-2x2", want: 3},
+		{values: "_x3", want: 3},
+		{values: "1 _ stale 0+1x100000000", want: 100000004},
+		{values: "0+1x18446744073709551615 1", want: math.MaxUint64},
+		{values: "1x", want: 1},
+	}
+	for _, tt := range tests {
+		if got := countSamples(tt.values); got != tt.want {
+			t.Errorf("countSamples(%q) = %d, want %d", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestRunLimits(t *testing.T) {
+	spec := v1alpha1.RuleSpec{Groups: []v1alpha1.RuleGroup{{
+		Name: "group",
+		Rules: []v1alpha1.Rule{{
+			Alert: "Down",
+			Expr:  intstr.FromString("up == 0"),
+		}},
+	}}}
+	ns, err := spec.GetMimirRuleNamespace("namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	passing := v1alpha1.RuleTest{
+		InputSeries: []v1alpha1.InputSeries{{Series: `up{job="a"}`, Values: "1 0 0"}},
+		AlertRuleTests: []v1alpha1.AlertRuleTest{{
+			EvalTime:  "2m",
+			Alertname: "Down",
+			ExpAlerts: []v1alpha1.ExpectedAlert{{ExpLabels: map[string]string{"job": "a"}}},
+		}},
+	}
+	withSeries := func(n int) v1alpha1.RuleTest {
+		test := passing
+		test.InputSeries = nil
+		for i := 0; i < n; i++ {
+			test.InputSeries = append(test.InputSeries, v1alpha1.InputSeries{Series: `up{job="a",i="` + strings.Repeat("x", i) + `"}`, Values: "1"})
+		}
+		return test
+	}
+	withEvalTime := func(evalTime v1alpha1.Duration) v1alpha1.RuleTest {
+		test := passing
+		test.AlertRuleTests = []v1alpha1.AlertRuleTest{{EvalTime: evalTime, Alertname: "Down"}}
+		return test
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		test    v1alpha1.RuleTest
+		limits  Limits
+		wantErr string
+	}{
+		{name: "passing", test: passing, limits: DefaultLimits},
+		{name: "too many series", test: withSeries(3), limits: Limits{MaxSeries: 2}, wantErr: "3 input series exceed the limit of 2"},
+		{
+			name:    "too many samples",
+			test:    v1alpha1.RuleTest{InputSeries: []v1alpha1.InputSeries{{Series: "up", Values: "0+1x100000000"}}},
+			limits:  DefaultLimits,
+			wantErr: "100000001 samples exceed the limit of 10000",
+		},
+		{name: "eval_time too late", test: withEvalTime("30d"), limits: Limits{MaxEvalTime: 7 * 24 * time.Hour}, wantErr: "eval_time 30d exceeds the limit of 1w"},
+		{name: "too many evaluations", test: withEvalTime("1w"), limits: DefaultLimits, wantErr: "more than the limit of 10000"},
+		{name: "cancelled", ctx: cancelled, test: passing, limits: DefaultLimits, wantErr: "context canceled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			start := time.Now()
+			errs := Run(ctx, ns, []v1alpha1.RuleTest{tt.test}, tt.limits)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Run() took %s", elapsed)
+			}
+			if tt.wantErr == "" {
+				if len(errs) > 0 {
+					t.Fatalf("Run() errors = %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Fatalf("Run() errors = %v, want one containing %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestRunTimeout checks that a slow test is aborted by the timeout.
+func TestRunTimeout(t *testing.T) {
+	spec := v1alpha1.RuleSpec{Groups: []v1alpha1.RuleGroup{{
+		Name:  "group",
+		Rules: []v1alpha1.Rule{{Record: "job:up:sum", Expr: intstr.FromString("sum by (job) (up)")}},
+	}}}
+	ns, err := spec.GetMimirRuleNamespace("namespace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := v1alpha1.RuleTest{
+		Interval:        "1s",
+		InputSeries:     []v1alpha1.InputSeries{{Series: "up", Values: "1+0x9000"}},
+		PromQLExprTests: []v1alpha1.PromQLExprTest{{Expr: "job:up:sum", EvalTime: "2h"}},
+	}
+	limits := DefaultLimits
+	limits.Timeout = 10 * time.Millisecond
+
+	start := time.Now()
+	errs := Run(context.Background(), ns, []v1alpha1.RuleTest{test, test}, limits)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() took %s", elapsed)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("Run() errors = %v, want 2", errs)
+	}
+	for _, err := range errs {
+		if !strings.Contains(err.Error(), "context deadline exceeded") {
+			t.Errorf("Run() error = %v, want a timeout", err)
+		}
+	}
+}
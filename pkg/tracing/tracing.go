@@ -0,0 +1,111 @@
+// Package tracing sets up OpenTelemetry tracing for the controller and holds
+// the span helpers and attributes shared by the other packages.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is the service name of the spans of the controller.
+const ServiceName = "mimir-rules-controller"
+
+const tracerName = "github.com/healthjoy/mimir-rules-controller"
+
+// DefaultSampleRatio is the default ratio of the sampled traces.
+const DefaultSampleRatio = 1.0
+
+// Span attributes.
+const (
+	// RuleKey is the namespace/name key of the MimirRule.
+	RuleKey = attribute.Key("mimir_rule.key")
+	// MimirNamespace is the Mimir namespace holding the rule groups.
+	MimirNamespace = attribute.Key("mimir.namespace")
+	// RuleGroup is the name of a rule group.
+	RuleGroup = attribute.Key("mimir.rule_group")
+	// Tenant is the Mimir tenant.
+	Tenant = attribute.Key("mimir.tenant")
+)
+
+// Options configure the export of the spans.
+type Options struct {
+	// Endpoint is the host:port of the OTLP gRPC receiver. Empty disables
+	// tracing.
+	Endpoint string
+	// Insecure disables TLS towards the receiver.
+	Insecure bool
+	// SampleRatio is the ratio of the sampled traces, unless the parent span
+	// was sampled.
+	SampleRatio float64
+	// Instance identifies the replica sending the spans.
+	Instance string
+}
+
+// Setup installs the global tracer provider exporting the spans to the OTLP
+// receiver of options, and returns the function flushing the pending spans
+// on shutdown. Without an endpoint the spans are dropped.
+func Setup(ctx context.Context, options Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if options.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	clientOptions := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(options.Endpoint)}
+	if options.Insecure {
+		clientOptions = append(clientOptions, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, clientOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := []attribute.KeyValue{semconv.ServiceName(ServiceName)}
+	if options.Instance != "" {
+		attrs = append(attrs, semconv.ServiceInstanceID(options.Instance))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, attrs...))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span of the controller.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, marking it as failed when err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID returns the ID of the trace of ctx, or an empty string when ctx
+// carries no span, e.g. because tracing is disabled.
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
@@ -0,0 +1,110 @@
+// Package validation holds the checks run against MimirRule resources before
+// their rule groups are pushed to Mimir.
+package validation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/mimir/pkg/mimirtool/rules"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/template"
+)
+
+// templateDefs are the convenience variables Prometheus injects in front of
+// every alert label and annotation template.
+var templateDefs = []string{
+	"{{$labels := .Labels}}",
+	"{{$externalLabels := .ExternalLabels}}",
+	"{{$externalURL := .ExternalURL}}",
+	"{{$value := .Value}}",
+}
+
+// TemplateError is a template error of a single alerting rule.
+type TemplateError struct {
+	Group    string
+	Rule     int
+	RuleName string
+	Err      error
+}
+
+// Error prints the error message in the same format as rulefmt.Error.
+func (err *TemplateError) Error() string {
+	return fmt.Sprintf("group %q, rule %d, %q: %v", err.Group, err.Rule, err.RuleName, err.Err)
+}
+
+// Unwrap unpacks wrapped error for use in errors.Is & errors.As.
+func (err *TemplateError) Unwrap() error {
+	return err.Err
+}
+
+// Templates parses and expands the labels and annotations of every alerting
+// rule in ns with the Prometheus alert template engine, the same way the ruler
+// does when an alert fires. Queries issued from templates return a single
+// empty sample, so only syntax and evaluation errors are reported.
+func Templates(ns *rules.RuleNamespace) []error {
+	var errs []error
+	now := time.Now()
+	data := template.AlertTemplateData(map[string]string{}, map[string]string{}, "", 0)
+
+	for _, g := range ns.Groups {
+		for i, r := range g.Rules {
+			if r.Alert.Value == "" {
+				continue
+			}
+
+			expand := func(kind, key, text string) {
+				tmpl := template.NewTemplateExpander(
+					context.Background(),
+					strings.Join(append(templateDefs, text), ""),
+					"__alert_"+r.Alert.Value,
+					data,
+					model.Time(timestamp.FromTime(now)),
+					emptyQuery,
+					nil,
+					nil,
+				)
+				if _, err := tmpl.Expand(); err != nil {
+					errs = append(errs, &TemplateError{
+						Group:    g.Name,
+						Rule:     i + 1,
+						RuleName: r.Alert.Value,
+						Err:      fmt.Errorf("%s %q: %w", kind, key, err),
+					})
+				}
+			}
+
+			for _, k := range sortedKeys(r.Labels) {
+				expand("label", k, r.Labels[k])
+			}
+			for _, k := range sortedKeys(r.Annotations) {
+				expand("annotation", k, r.Annotations[k])
+			}
+		}
+	}
+
+	return errs
+}
+
+// emptyQuery answers template queries with a single sample without labels.
+func emptyQuery(_ context.Context, _ string, ts time.Time) (promql.Vector, error) {
+	return promql.Vector{{
+		Point:  promql.Point{T: timestamp.FromTime(ts)},
+		Metric: labels.EmptyLabels(),
+	}}, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,82 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grafana/mimir/pkg/mimirtool/rules"
+	"github.com/grafana/mimir/pkg/mimirtool/rules/rwrulefmt"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gopkg.in/yaml.v3"
+)
+
+func TestTemplates(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		// wantErrs are the expected messages, in order
+		wantErrs []string
+	}{
+		{
+			name:        "valid",
+			labels:      map[string]string{"severity": "{{ if gt $value 1.0 }}critical{{ else }}warning{{ end }}"},
+			annotations: map[string]string{"summary": "{{ $labels.job }} is down, {{ $value | humanize }}"},
+		},
+		{
+			name:        "syntax error",
+			annotations: map[string]string{"summary": "{{ $labels.job "},
+			wantErrs:    []string{`group "group", rule 2, "Down": annotation "summary": `},
+		},
+		{
+			name:        "unknown function",
+			annotations: map[string]string{"summary": "{{ $value | humanise }}"},
+			wantErrs:    []string{`group "group", rule 2, "Down": annotation "summary": `},
+		},
+		{
+			name:        "label and annotation",
+			labels:      map[string]string{"severity": `{{ template "missing" . }}`},
+			annotations: map[string]string{"description": "{{ .Missing }}", "summary": "ok"},
+			wantErrs: []string{
+				`group "group", rule 2, "Down": label "severity": `,
+				`group "group", rule 2, "Down": annotation "description": `,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := &rules.RuleNamespace{Groups: []rwrulefmt.RuleGroup{{RuleGroup: rulefmt.RuleGroup{
+				Name: "group",
+				Rules: []rulefmt.RuleNode{
+					{
+						// Recording rules have no templates
+						Record: yaml.Node{Kind: yaml.ScalarNode, Value: "job:up:sum"},
+						Expr:   yaml.Node{Kind: yaml.ScalarNode, Value: "sum by (job) (up)"},
+						Labels: map[string]string{"team": "{{ broken"},
+					},
+					{
+						Alert:       yaml.Node{Kind: yaml.ScalarNode, Value: "Down"},
+						Expr:        yaml.Node{Kind: yaml.ScalarNode, Value: "up == 0"},
+						Labels:      tt.labels,
+						Annotations: tt.annotations,
+					},
+				},
+			}}}}
+
+			errs := Templates(ns)
+			if len(errs) != len(tt.wantErrs) {
+				t.Fatalf("Templates() = %v, want %d errors", errs, len(tt.wantErrs))
+			}
+			for i, err := range errs {
+				if !strings.HasPrefix(err.Error(), tt.wantErrs[i]) {
+					t.Errorf("error %d = %q, want prefix %q", i, err.Error(), tt.wantErrs[i])
+				}
+				var templateErr *TemplateError
+				if !errors.As(err, &templateErr) || templateErr.Rule != 2 || errors.Unwrap(err) == nil {
+					t.Errorf("error %d = %#v, want a TemplateError of rule 2", i, err)
+				}
+			}
+		})
+	}
+}
@@ -21,12 +21,17 @@ set -o pipefail
 SCRIPT_ROOT=$(dirname "${BASH_SOURCE[0]}")/..
 CODEGEN_PKG=${CODEGEN_PKG:-$(cd "${SCRIPT_ROOT}"; ls -d -1 ./vendor/k8s.io/code-generator 2>/dev/null || echo ../code-generator)}
 
-# generate the code with:
-# --output-base    because this script should also be able to run inside the vendor dir of
-#                  k8s.io/kubernetes. The output-base is needed for the generators to output into the vendor dir
-#                  instead of the $GOPATH directly. For normal projects this can be dropped.
-"${CODEGEN_PKG}"/generate-groups.sh "deepcopy,client,informer,lister" \
-  github.com/healthjoy/mimir-rules-controller/pkg/generated github.com/healthjoy/mimir-rules-controller/pkg/apis \
-  rulescontroller:v1alpha1 \
-  --output-base "$(dirname "${BASH_SOURCE[0]}")/../../../.." \
-  --go-header-file "${SCRIPT_ROOT}"/tools/boilerplate.go.txt
+source "${CODEGEN_PKG}/kube_codegen.sh"
+
+THIS_PKG="github.com/healthjoy/mimir-rules-controller"
+
+kube::codegen::gen_helpers \
+  --boilerplate "${SCRIPT_ROOT}/tools/boilerplate.go.txt" \
+  "${SCRIPT_ROOT}/pkg/apis"
+
+kube::codegen::gen_client \
+  --with-watch \
+  --output-dir "${SCRIPT_ROOT}/pkg/generated" \
+  --output-pkg "${THIS_PKG}/pkg/generated" \
+  --boilerplate "${SCRIPT_ROOT}/tools/boilerplate.go.txt" \
+  "${SCRIPT_ROOT}/pkg/apis"